@@ -1,58 +1,178 @@
 package main
 
 import (
-	"crypto/sha256"
-	"fmt"
-	"io"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/felixsolom/http-from-tcp/internal/accesslog"
+	"github.com/felixsolom/http-from-tcp/internal/admin"
 	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/metrics"
+	"github.com/felixsolom/http-from-tcp/internal/proxy"
 	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/requestid"
 	"github.com/felixsolom/http-from-tcp/internal/response"
 	"github.com/felixsolom/http-from-tcp/internal/server"
+	"github.com/felixsolom/http-from-tcp/internal/sse"
+	"github.com/felixsolom/http-from-tcp/internal/tracing"
+	"github.com/felixsolom/http-from-tcp/internal/websocket"
 )
 
 const port = 42069
+const adminPort = 42070
+const maxDecodedBodySize = 10 << 20
+
+var httpbinProxy *proxy.Proxy
+var registry = metrics.NewRegistry()
+
+// spans go to stderr so they don't mix with the access log
+var tracer = tracing.NewTracer(tracing.NewJSONExporter(os.Stderr))
 
 func main() {
-	server, err := server.Serve(port, handler)
+	var err error
+	httpbinProxy, err = proxy.New("https://httpbin.org/",
+		proxy.WithStripPrefix("/httpbin/"),
+		proxy.WithTrailers(),
+		proxy.WithTracer(tracer),
+	)
+	if err != nil {
+		log.Fatalf("Error setting up proxy: %v", err)
+	}
+
+	server, err := server.Serve(port, server.Chain(handler,
+		requestid.Middleware,
+		accesslog.Middleware(accesslog.NewLogger(os.Stdout, accesslog.Combined)),
+		metrics.Middleware(registry, routeName),
+		tracing.Middleware(tracer),
+		server.Compress,
+		server.DecodeRequestBody(maxDecodedBodySize),
+	), server.WithH2C())
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 	defer server.Close()
+	metrics.RegisterServer(registry, server)
 	log.Println("Server started on port", port)
 
+	// the admin listener is off unless a token is set
+	drained := make(chan struct{})
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		adminServer, err := admin.Serve(adminPort, server, token, admin.WithOnDrained(func() { close(drained) }))
+		if err != nil {
+			log.Fatalf("Error starting admin server: %v", err)
+		}
+		defer adminServer.Close()
+		log.Println("Admin server started on localhost port", adminPort)
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	select {
+	case <-sigChan:
+	case <-drained:
+		log.Println("Server drained through the admin listener")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("Shutdown cut short:", err)
+	}
 	log.Println("Server gracefully stopped")
 }
 
-func handler(w *response.Writer, req *request.Request) {
-	if req.RequestLine.RequestTarget == "/video" {
-		videoHandler(w, req)
-		return
+// routes maps request targets to handlers, targets ending in a slash match
+// as prefixes. Anything unmatched gets handler200.
+var routes = []struct {
+	target  string
+	handler server.Handler
+}{
+	{"/video", videoHandler},
+	{"/httpbin/", func(w *response.Writer, req *request.Request) { httpbinProxy.Handle(w, req) }},
+	{"/report", reportHandler},
+	{"/echo", echoHandler},
+	{"/events", eventsHandler},
+	{"/metrics", metrics.Handler(registry)},
+	{"/yourproblem", handler400},
+	{"/myproblem", handler500},
+}
+
+// route finds the handler for req along with the target it matched, which
+// is what metrics are labelled with.
+func route(req *request.Request) (string, server.Handler) {
+	target := req.RequestLine.RequestTarget
+	for _, r := range routes {
+		if target == r.target || (strings.HasSuffix(r.target, "/") && strings.HasPrefix(target, r.target)) {
+			return r.target, r.handler
+		}
 	}
+	return "/", handler200
+}
+
+func handler(w *response.Writer, req *request.Request) {
+	_, h := route(req)
+	h(w, req)
+}
+
+func routeName(req *request.Request) string {
+	name, _ := route(req)
+	return name
+}
 
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		proxyHandler(w, req)
+// echoHandler sends every WebSocket message straight back.
+func echoHandler(w *response.Writer, req *request.Request) {
+	conn, err := websocket.Upgrade(w, req, websocket.WithCompression())
+	if err != nil {
+		log.Printf("Couldn't upgrade to websocket: %v", err)
 		return
 	}
-	if req.RequestLine.RequestTarget == "/yourproblem" {
-		handler400(w, req)
+	go func() {
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(msgType, data); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// eventsHandler counts to 100 as server-sent events, one per second.
+// Reconnecting clients carry on where they left off.
+func eventsHandler(w *response.Writer, req *request.Request) {
+	stream, err := sse.NewStream(w, req)
+	if err != nil {
+		log.Printf("Couldn't start event stream: %v", err)
 		return
 	}
-	if req.RequestLine.RequestTarget == "/myproblem" {
-		handler500(w, req)
-		return
+	defer stream.Close()
+
+	n, _ := strconv.Atoi(stream.LastEventID())
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for n < 100 {
+		select {
+		case <-stream.Done():
+			return
+		case <-ticker.C:
+		}
+		n++
+		id := strconv.Itoa(n)
+		if err := stream.Send(sse.Event{ID: id, Event: "progress", Data: id}); err != nil {
+			return
+		}
 	}
-	handler200(w, req)
-	return
 }
 
 func handler400(w *response.Writer, _ *request.Request) {
@@ -97,8 +217,7 @@ func handler500(w *response.Writer, _ *request.Request) {
 	return
 }
 
-func handler200(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.OK)
+func handler200(w *response.Writer, req *request.Request) {
 	body := []byte(
 		`
 		<html>
@@ -111,90 +230,60 @@ func handler200(w *response.Writer, _ *request.Request) {
 			</body>
 			</html>`,
 	)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
-	return
+	if err := response.ServeBytes(w, req, "text/html", body); err != nil {
+		log.Printf("Couldn't write response: %v", err)
+	}
 }
 
-func proxyHandler(w *response.Writer, req *request.Request) {
-	proxyPrefex := "/httpbin/"
-	targetServer := "https://httpbin.org/"
+var report = [][]string{
+	{"route", "description"},
+	{"/", "the happy path"},
+	{"/yourproblem", "a 400 page"},
+	{"/myproblem", "a 500 page"},
+	{"/video", "range enabled video"},
+	{"/httpbin/", "proxy to httpbin.org"},
+	{"/events", "server-sent progress events"},
+	{"/echo", "websocket echo"},
+	{"/metrics", "prometheus metrics"},
+	{"/report", "this report, as JSON or CSV"},
+}
 
-	if !strings.HasPrefix(req.RequestLine.RequestTarget, proxyPrefex) {
-		handler400(w, req)
-		return
-	}
-	targetURL := targetServer + strings.TrimPrefix(req.RequestLine.RequestTarget, proxyPrefex)
-	proxyRes, err := http.Get(targetURL)
+func reportHandler(w *response.Writer, req *request.Request) {
+	offers := []string{"application/json", "text/csv"}
+	accept := headers.ParseAccept(req.Headers["accept"])
+	contentType, err := accept.Negotiate(offers)
 	if err != nil {
-		log.Printf("Couldn't get a response from http_bin: %v", err)
+		response.WriteError(w, response.NotAcceptable, "supported types: "+strings.Join(offers, ", "))
 		return
 	}
-	defer proxyRes.Body.Close()
 
-	w.WriteStatusLine(response.StatusCode(proxyRes.StatusCode))
-	h := response.GetDefaultHeaders(0)
-	for key, value := range proxyRes.Header {
-		h.Set(key, strings.Join(value, ","))
-	}
-	delete(h, strings.ToLower("Content-Length"))
-	h.Set("Transfer-Encoding", "chunked")
-	h.Set("Trailer", "X-Content-SHA256, X-Content-Length")
-	w.WriteHeaders(h)
-
-	checkSum := sha256.New()
-	var totalBytes int64 = 0
-	for {
-		buf := make([]byte, 1024)
-		n, err := proxyRes.Body.Read(buf)
-		if n > 0 {
-			_, err = w.WriteChunkedBody(buf[:n])
-			checkSum.Write(buf[:n])
-			totalBytes += int64(n)
-			if err != nil {
-				log.Printf("Couldn't write chunk to body: %v", err)
-				break
-			}
-			go func() { log.Printf("read: %d bytes\n", n) }()
-		}
-		if err == io.EOF {
-			log.Printf("reached EOF")
-			break
+	var body bytes.Buffer
+	switch contentType {
+	case "text/csv":
+		csvWriter := csv.NewWriter(&body)
+		csvWriter.WriteAll(report)
+	default:
+		rows := make([]map[string]string, 0, len(report)-1)
+		for _, row := range report[1:] {
+			rows = append(rows, map[string]string{report[0][0]: row[0], report[0][1]: row[1]})
 		}
-
-		if err != nil {
-			log.Printf("Coudn't read response body: %v", err)
-			break
-		}
-
+		json.NewEncoder(&body).Encode(rows)
 	}
-	if _, err = w.WriteChunkedBodyDone(); err != nil {
-		log.Printf("Couldn't write end chunk to response: %v", err)
-	}
-	log.Printf("finished writing to response body")
 
-	t := headers.NewHeaders()
-	t["X-Content-SHA256"] = fmt.Sprintf("%x", checkSum.Sum(nil))
-	t["X-Content-Length"] = fmt.Sprint(totalBytes)
-	if err := w.WriteTrailers(t); err != nil {
-		log.Printf("Couldn't write trailers to response: %v", err)
+	err = response.ServeContent(w, req, response.Content{
+		Body:        bytes.NewReader(body.Bytes()),
+		Size:        int64(body.Len()),
+		ContentType: contentType,
+		ETag:        response.BodyETag(body.Bytes(), false),
+		Headers:     headers.Headers{"Vary": "Accept"},
+	})
+	if err != nil {
+		log.Printf("Couldn't write report: %v", err)
 	}
-	log.Println("Finished writing trailers")
 }
 
-func videoHandler(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.OK)
-	body, err := os.ReadFile("./assets/vim.mp4")
-	if err != nil {
-		log.Printf("Couldn't read file from server: %v", err)
-		return
+func videoHandler(w *response.Writer, req *request.Request) {
+	if err := response.ServeFile(w, req, "./assets/vim.mp4"); err != nil {
+		log.Printf("Couldn't serve video: %v", err)
 	}
-	h := response.GetDefaultHeaders(0)
-	h.Set("Content-Type", "video/mp4")
-	delete(h, "Content-Length")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
-	return
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/felixsolom/http-from-tcp/internal/metrics"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		statusCode  response.StatusCode
+		contentType string
+		contains    string
+	}{
+		{"happy path", "/", response.OK, "text/html", "Your request was an absolute banger."},
+		{"client error", "/yourproblem", response.BadRequest, "text/html", "Your request honestly kinda sucked."},
+		{"server error", "/myproblem", response.InternalServerError, "text/html", "This one is on me."},
+		{"report", "/report", response.OK, "application/json", `"route":"/report"`},
+		{"metrics", "/metrics", response.OK, metrics.ContentType, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := servertest.Record(handler, servertest.NewRequest("GET", tc.target, nil))
+			require.NoError(t, err)
+			assert.Equal(t, tc.statusCode, res.StatusLine.StatusCode)
+			assert.Equal(t, tc.contentType, res.Headers["content-type"])
+			assert.Contains(t, string(res.Body), tc.contains)
+		})
+	}
+}
+
+func TestReportNegotiation(t *testing.T) {
+	// Test: CSV when asked for
+	req := servertest.NewRequest("GET", "/report", nil)
+	req.Headers["accept"] = "text/csv, application/json;q=0.5"
+	res, err := servertest.Record(handler, req)
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, res.StatusLine.StatusCode)
+	assert.Equal(t, "text/csv", res.Headers["content-type"])
+	assert.Contains(t, string(res.Body), "route,description\n")
+
+	// Test: JSON parses
+	req = servertest.NewRequest("GET", "/report", nil)
+	req.Headers["accept"] = "application/*"
+	res, err = servertest.Record(handler, req)
+	require.NoError(t, err)
+	var rows []map[string]string
+	require.NoError(t, json.Unmarshal(res.Body, &rows))
+	assert.Len(t, rows, len(report)-1)
+
+	// Test: Nothing we can produce
+	req = servertest.NewRequest("GET", "/report", nil)
+	req.Headers["accept"] = "image/png"
+	res, err = servertest.Record(handler, req)
+	require.NoError(t, err)
+	assert.Equal(t, response.NotAcceptable, res.StatusLine.StatusCode)
+}
@@ -2,7 +2,10 @@ module github.com/felixsolom/http-from-tcp
 
 go 1.24.5
 
-require github.com/stretchr/testify v1.11.1
+require (
+	github.com/stretchr/testify v1.11.1
+	golang.org/x/net v0.44.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
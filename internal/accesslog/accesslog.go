@@ -0,0 +1,89 @@
+package accesslog
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/server"
+)
+
+// Attribute keys of an access log record.
+const (
+	KeyRemoteAddr = "remote_addr"
+	KeyMethod     = "method"
+	KeyTarget     = "target"
+	KeyProto      = "proto"
+	KeyStatus     = "status"
+	KeyBytes      = "bytes"
+	KeyDuration   = "duration"
+	KeyReferer    = "referer"
+	KeyUserAgent  = "user_agent"
+	KeyRequestID  = "request_id"
+)
+
+type Option func(*config)
+
+type config struct {
+	sampleRate float64
+}
+
+// WithSampleRate logs only a fraction rate of the requests, picked at
+// random. Server errors are always logged.
+func WithSampleRate(rate float64) Option {
+	return func(c *config) { c.sampleRate = rate }
+}
+
+// Middleware logs a record for every request once the wrapped handler is
+// done. Its time is when the request was received.
+func Middleware(logger *slog.Logger, opts ...Option) server.Middleware {
+	cfg := config{sampleRate: 1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+			duration := time.Since(start)
+
+			if w.StatusCode() < 500 && cfg.sampleRate < 1 && rand.Float64() >= cfg.sampleRate {
+				return
+			}
+			ctx := req.Context()
+			if !logger.Enabled(ctx, slog.LevelInfo) {
+				return
+			}
+			received := req.ReceivedAt
+			if received.IsZero() {
+				received = start
+			}
+			record := slog.NewRecord(received, slog.LevelInfo, "request", 0)
+			record.AddAttrs(attrs(w, req, duration)...)
+			logger.Handler().Handle(ctx, record)
+		}
+	}
+}
+
+func attrs(w *response.Writer, req *request.Request, duration time.Duration) []slog.Attr {
+	referer, _ := req.Headers.Lookup("Referer")
+	userAgent, _ := req.Headers.Lookup("User-Agent")
+	requestID := w.RequestID()
+	if requestID == "" {
+		requestID, _ = req.Headers.Lookup("X-Request-ID")
+	}
+	return []slog.Attr{
+		slog.String(KeyRemoteAddr, req.RemoteAddr),
+		slog.String(KeyMethod, req.RequestLine.Method),
+		slog.String(KeyTarget, req.RequestLine.RequestTarget),
+		slog.String(KeyProto, "HTTP/"+req.RequestLine.HttpVersion),
+		slog.Int(KeyStatus, int(w.StatusCode())),
+		slog.Int64(KeyBytes, w.BytesWritten()),
+		slog.Duration(KeyDuration, duration),
+		slog.String(KeyReferer, referer),
+		slog.String(KeyUserAgent, userAgent),
+		slog.String(KeyRequestID, requestID),
+	}
+}
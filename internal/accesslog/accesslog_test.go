@@ -0,0 +1,145 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/server"
+	"github.com/felixsolom/http-from-tcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func textHandler(w *response.Writer, req *request.Request) {
+	body := []byte("hello")
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func logRequest(t *testing.T, format Format, handler server.Handler) string {
+	var out bytes.Buffer
+	req := servertest.NewRequest("GET", "/index.html?q=1", nil)
+	req.ReceivedAt = time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60))
+	req.Headers["referer"] = "http://example.com/start"
+	req.Headers["user-agent"] = `curl/8.0 "quoted"`
+	req.Headers["x-request-id"] = "abc123"
+	_, err := servertest.Record(Middleware(NewLogger(&out, format))(handler), req)
+	require.NoError(t, err)
+	return out.String()
+}
+
+func TestFormats(t *testing.T) {
+	// Test: Common Log Format
+	assert.Equal(t, `192.0.2.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html?q=1 HTTP/1.1" 200 5`+"\n",
+		logRequest(t, Common, textHandler))
+
+	// Test: Combined adds referer and an escaped user agent
+	assert.Equal(t, `192.0.2.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html?q=1 HTTP/1.1" 200 5 "http://example.com/start" "curl/8.0 \"quoted\""`+"\n",
+		logRequest(t, Combined, textHandler))
+
+	// Test: JSON has everything
+	var record map[string]any
+	require.NoError(t, json.Unmarshal([]byte(logRequest(t, JSON, textHandler)), &record))
+	assert.Equal(t, "GET", record[KeyMethod])
+	assert.Equal(t, "/index.html?q=1", record[KeyTarget])
+	assert.Equal(t, float64(200), record[KeyStatus])
+	assert.Equal(t, float64(5), record[KeyBytes])
+	assert.Equal(t, servertest.DefaultRemoteAddr, record[KeyRemoteAddr])
+	assert.Equal(t, "abc123", record[KeyRequestID])
+	assert.Contains(t, record, KeyDuration)
+	assert.Equal(t, "2000-10-10T13:55:36-07:00", record["time"])
+
+	// Test: The ID the response was tagged with wins over the header
+	require.NoError(t, json.Unmarshal([]byte(logRequest(t, JSON, func(w *response.Writer, req *request.Request) {
+		w.SetRequestID("assigned")
+		textHandler(w, req)
+	})), &record))
+	assert.Equal(t, "assigned", record[KeyRequestID])
+
+	// Test: No body shows as a dash
+	line := logRequest(t, Common, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.NotModified)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})
+	assert.True(t, strings.HasSuffix(line, `"GET /index.html?q=1 HTTP/1.1" 304 -`+"\n"))
+}
+
+func TestSampling(t *testing.T) {
+	var out bytes.Buffer
+	logged := Middleware(NewLogger(&out, Common), WithSampleRate(0))
+
+	// Test: A rate of 0 drops successes
+	servertest.Record(logged(textHandler), servertest.NewRequest("GET", "/", nil))
+	assert.Zero(t, out.Len())
+
+	// Test: Server errors are always kept
+	servertest.Record(logged(func(w *response.Writer, req *request.Request) {
+		response.WriteError(w, response.InternalServerError, "")
+	}), servertest.NewRequest("GET", "/", nil))
+	assert.Contains(t, out.String(), `"GET / HTTP/1.1" 500`)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	// Test: Newest in the file, two backups, the oldest gone
+	read := func(name string) string {
+		b, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(b)
+	}
+	assert.Equal(t, "fourth\n", read(path))
+	assert.Equal(t, "third\n", read(path+".1"))
+	assert.Equal(t, "second\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3")
+
+	// Test: Reopening appends
+	require.NoError(t, f.Close())
+	f, err = OpenRotatingFile(path, 100, 2)
+	require.NoError(t, err)
+	f.Write([]byte("fifth\n"))
+	assert.Equal(t, "fourth\nfifth\n", read(path))
+
+	// Test: A failed rotation keeps the file open and the line in it
+	require.NoError(t, f.Close())
+	path = filepath.Join(t.TempDir(), "access.log")
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "in-the-way"), 0o755))
+	f, err = OpenRotatingFile(path, 10, 1)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("first\n"))
+	require.NoError(t, err)
+	n, err := f.Write([]byte("second\n"))
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, os.ErrClosed)
+	assert.Equal(t, len("second\n"), n)
+	assert.Equal(t, "first\nsecond\n", read(path))
+
+	// Test: Rotating isn't retried on every write after that
+	_, err = f.Write([]byte("third\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\nthird\n", read(path))
+
+	// Test: The next attempt rotates once the way is clear
+	require.NoError(t, os.RemoveAll(path+".1"))
+	f.retryRotate = time.Time{}
+	_, err = f.Write([]byte("fourth\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "fourth\n", read(path))
+	assert.Equal(t, "first\nsecond\nthird\n", read(path+".1"))
+}
@@ -0,0 +1,124 @@
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Format int
+
+const (
+	// Common is the Apache Common Log Format.
+	Common Format = iota
+	// Combined is Common followed by the referer and user agent.
+	Combined
+	// JSON writes one object per request with every attribute.
+	JSON
+)
+
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// NewLogger returns a logger writing access log lines to w in format.
+func NewLogger(w io.Writer, format Format) *slog.Logger {
+	if format == JSON {
+		return slog.New(slog.NewJSONHandler(w, nil))
+	}
+	return slog.New(&clfHandler{mu: &sync.Mutex{}, w: w, combined: format == Combined})
+}
+
+// clfHandler renders records as Common or Combined Log Format lines. Only
+// the attributes Middleware adds are used, anything else is dropped since
+// the formats have no place for it.
+type clfHandler struct {
+	mu       *sync.Mutex
+	w        io.Writer
+	combined bool
+	attrs    []slog.Attr
+}
+
+func (h *clfHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *clfHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := map[string]slog.Value{}
+	for _, a := range h.attrs {
+		fields[a.Key] = a.Value
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		fields[a.Key] = a.Value
+		return true
+	})
+	str := func(key string) string {
+		if v, ok := fields[key]; ok {
+			return v.String()
+		}
+		return ""
+	}
+
+	host := str(KeyRemoteAddr)
+	if hostOnly, _, err := net.SplitHostPort(host); err == nil {
+		host = hostOnly
+	}
+	number := func(key string) string {
+		if v, ok := fields[key]; ok && v.Kind() == slog.KindInt64 && v.Int64() != 0 {
+			return strconv.FormatInt(v.Int64(), 10)
+		}
+		return "-"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - - [%s] \"%s %s %s\" %s %s", orDash(host), r.Time.Format(clfTimeLayout),
+		escape(str(KeyMethod)), escape(str(KeyTarget)), escape(str(KeyProto)), number(KeyStatus), number(KeyBytes))
+	if h.combined {
+		fmt.Fprintf(&b, " \"%s\" \"%s\"", orDash(escape(str(KeyReferer))), orDash(escape(str(KeyUserAgent))))
+	}
+	b.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *clfHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &h2
+}
+
+// WithGroup is a no-op, the formats are flat.
+func (h *clfHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escape keeps client supplied values from breaking out of their quotes or
+// forging lines, the way Apache does it.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
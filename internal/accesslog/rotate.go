@@ -0,0 +1,135 @@
+package accesslog
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// rotateRetryInterval is how long a file that failed to rotate keeps
+// growing before rotating is tried again.
+const rotateRetryInterval = time.Minute
+
+// RotatingFile is an append-only log file that is moved aside once it
+// would grow past a size limit. Older files are kept as path.1, path.2 and
+// so on, path.1 being the most recent.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu          sync.Mutex
+	file        *os.File
+	size        int64
+	retryRotate time.Time
+}
+
+// OpenRotatingFile opens path for appending, keeping at most maxBackups
+// rotated files next to it.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("accesslog: max size must be positive, got %d", maxSize)
+	}
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p, rotating first when p doesn't fit anymore. A single
+// write larger than the limit still goes into one file. When rotating fails
+// p is still appended and the error returned, and the file grows past the
+// limit until the next attempt.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize && !time.Now().Before(f.retryRotate) {
+		if err := f.rotate(); err != nil {
+			if f.file == nil {
+				return 0, err
+			}
+			f.retryRotate = time.Now().Add(rotateRetryInterval)
+			n, writeErr := f.file.Write(p)
+			f.size += int64(n)
+			return n, errors.Join(err, writeErr)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("accesslog: couldn't open %s: %w", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("accesslog: couldn't stat %s: %w", f.path, err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate shifts the backups up by one, dropping the oldest, and starts a
+// fresh file. When that fails the current file is reopened, so later writes
+// still land somewhere. f.mu must be held.
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err == nil {
+		err = f.shift()
+	}
+	if err != nil {
+		if openErr := f.open(); openErr != nil {
+			return errors.Join(err, openErr)
+		}
+		return err
+	}
+	return f.open()
+}
+
+// shift moves path and its backups out of the way.
+func (f *RotatingFile) shift() error {
+	if f.maxBackups <= 0 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("accesslog: couldn't rotate: %w", err)
+		}
+		return nil
+	}
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(f.backup(i), f.backup(i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("accesslog: couldn't rotate: %w", err)
+		}
+	}
+	if err := os.Rename(f.path, f.backup(1)); err != nil {
+		return fmt.Errorf("accesslog: couldn't rotate: %w", err)
+	}
+	return nil
+}
+
+func (f *RotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
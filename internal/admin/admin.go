@@ -0,0 +1,305 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/server"
+)
+
+const defaultDrainTimeout = 30 * time.Second
+
+var ErrNoToken = errors.New("admin: a token is required")
+
+// started stands in for when the process started.
+var started = time.Now()
+
+type Option func(*admin)
+
+type admin struct {
+	target       *server.Server
+	token        []byte
+	drainTimeout time.Duration
+	serverOpts   []server.Option
+	onDrained    func()
+
+	draining atomic.Bool
+}
+
+// WithDrainTimeout bounds how long a drain waits for requests in flight
+// before cancelling them.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(a *admin) { a.drainTimeout = d }
+}
+
+// WithOnDrained has f called once a drain is over, whether the requests in
+// flight finished or were cut short, so the process can exit.
+func WithOnDrained(f func()) Option {
+	return func(a *admin) { a.onDrained = f }
+}
+
+// WithServerOptions configures the admin listener started by Serve, such as
+// server.WithHost to make it reachable from other machines.
+func WithServerOptions(opts ...server.Option) Option {
+	return func(a *admin) { a.serverOpts = append(a.serverOpts, opts...) }
+}
+
+// Serve runs Handler on a listener of its own, port 0 meaning any free one.
+// It only listens on localhost unless told otherwise with
+// WithServerOptions.
+func Serve(port int, target *server.Server, token string, opts ...Option) (*server.Server, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+	a := newAdmin(target, token, opts)
+	serverOpts := append([]server.Option{server.WithLocalhostOnly()}, a.serverOpts...)
+	return server.Serve(port, a.serve, serverOpts...)
+}
+
+// Handler serves the admin endpoints for target to requests with the bearer
+// token in their Authorization header. With an empty token every request
+// is refused.
+//
+//	GET  /conns          live connections
+//	GET  /config         how target was set up
+//	GET  /runtime        goroutines, memory and the like
+//	POST /drain          gracefully shut target down
+//	GET  /debug/pprof/   profiles
+func Handler(target *server.Server, token string, opts ...Option) server.Handler {
+	return newAdmin(target, token, opts).serve
+}
+
+func newAdmin(target *server.Server, token string, opts []Option) *admin {
+	a := &admin{target: target, token: []byte(token), drainTimeout: defaultDrainTimeout}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+func (a *admin) serve(w *response.Writer, req *request.Request) {
+	if !a.authorized(req) {
+		h := response.GetDefaultHeaders(0)
+		h.Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeText(w, response.Unauthorized, h, "401 Unauthorized\n")
+		return
+	}
+
+	path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	method := req.RequestLine.Method
+	switch {
+	case strings.HasPrefix(path, pprofPrefix):
+		a.pprof(w, req, strings.TrimPrefix(path, pprofPrefix))
+	case path == "/drain":
+		if method != "POST" {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		a.drain(w)
+	case path == "/conns" || path == "/config" || path == "/runtime":
+		if method != "GET" {
+			methodNotAllowed(w, "GET")
+			return
+		}
+		switch path {
+		case "/conns":
+			writeJSON(w, response.OK, a.conns())
+		case "/config":
+			writeJSON(w, response.OK, a.config())
+		case "/runtime":
+			writeJSON(w, response.OK, runtimeStatus())
+		}
+	default:
+		response.WriteError(w, response.NotFound, "")
+	}
+}
+
+func (a *admin) authorized(req *request.Request) bool {
+	auth, _ := req.Headers.Lookup("Authorization")
+	scheme, token, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || len(a.token) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), a.token) == 1
+}
+
+// drain shuts the target down in the background, the admin listener stays
+// up to watch it happen.
+func (a *admin) drain(w *response.Writer) {
+	if !a.draining.CompareAndSwap(false, true) {
+		response.WriteError(w, response.Conflict, "already draining")
+		return
+	}
+	go func() {
+		if a.onDrained != nil {
+			defer a.onDrained()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), a.drainTimeout)
+		defer cancel()
+		log.Println("Draining server")
+		if err := a.target.Shutdown(ctx); err != nil {
+			log.Println("Drain cut short:", err)
+			return
+		}
+		log.Println("Server drained")
+	}()
+	writeJSON(w, response.Accepted, map[string]string{"status": "draining"})
+}
+
+type connJSON struct {
+	ID         uint64        `json:"id"`
+	RemoteAddr string        `json:"remote_addr"`
+	LocalAddr  string        `json:"local_addr"`
+	Proto      string        `json:"proto,omitempty"`
+	State      string        `json:"state"`
+	BytesIn    uint64        `json:"bytes_in"`
+	BytesOut   uint64        `json:"bytes_out"`
+	Opened     time.Time     `json:"opened"`
+	AgeSeconds float64       `json:"age_seconds"`
+	Requests   []requestJSON `json:"requests"`
+}
+
+type requestJSON struct {
+	Seq            uint64    `json:"seq"`
+	Method         string    `json:"method"`
+	Target         string    `json:"target"`
+	Started        time.Time `json:"started"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+}
+
+func (a *admin) conns() []connJSON {
+	now := time.Now()
+	conns := []connJSON{}
+	for _, c := range a.target.Conns() {
+		requests := []requestJSON{}
+		for _, r := range c.Requests {
+			requests = append(requests, requestJSON{
+				Seq:            r.Seq,
+				Method:         r.Method,
+				Target:         r.Target,
+				Started:        r.Started,
+				ElapsedSeconds: now.Sub(r.Started).Seconds(),
+			})
+		}
+		conns = append(conns, connJSON{
+			ID:         c.ID,
+			RemoteAddr: c.RemoteAddr,
+			LocalAddr:  c.LocalAddr,
+			Proto:      c.Proto,
+			State:      string(c.State),
+			BytesIn:    c.BytesIn,
+			BytesOut:   c.BytesOut,
+			Opened:     c.Opened,
+			AgeSeconds: now.Sub(c.Opened).Seconds(),
+			Requests:   requests,
+		})
+	}
+	return conns
+}
+
+type configJSON struct {
+	Network        string    `json:"network"`
+	Addr           string    `json:"addr"`
+	TLS            bool      `json:"tls"`
+	MinTLSVersion  string    `json:"min_tls_version,omitempty"`
+	ClientAuth     string    `json:"client_auth,omitempty"`
+	H2C            bool      `json:"h2c"`
+	WriteBuffer    int       `json:"write_buffer"`
+	RequestTimeout string    `json:"request_timeout"`
+	Stats          statsJSON `json:"stats"`
+}
+
+type statsJSON struct {
+	ActiveConns    int64             `json:"active_conns"`
+	AcceptedConns  uint64            `json:"accepted_conns"`
+	BytesIn        uint64            `json:"bytes_in"`
+	BytesOut       uint64            `json:"bytes_out"`
+	ReusedRequests uint64            `json:"reused_requests"`
+	ParseErrors    map[string]uint64 `json:"parse_errors"`
+}
+
+func (a *admin) config() configJSON {
+	c := a.target.Config()
+	st := a.target.Stats()
+	return configJSON{
+		Network:        c.Network,
+		Addr:           c.Addr,
+		TLS:            c.TLS,
+		MinTLSVersion:  c.MinTLSVersion,
+		ClientAuth:     c.ClientAuth,
+		H2C:            c.H2C,
+		WriteBuffer:    c.WriteBuffer,
+		RequestTimeout: c.RequestTimeout.String(),
+		Stats: statsJSON{
+			ActiveConns:    st.ActiveConns,
+			AcceptedConns:  st.AcceptedConns,
+			BytesIn:        st.BytesIn,
+			BytesOut:       st.BytesOut,
+			ReusedRequests: st.ReusedRequests,
+			ParseErrors:    st.ParseErrors,
+		},
+	}
+}
+
+type runtimeJSON struct {
+	GoVersion     string  `json:"go_version"`
+	Goroutines    int     `json:"goroutines"`
+	GOMAXPROCS    int     `json:"gomaxprocs"`
+	NumCPU        int     `json:"num_cpu"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	HeapAlloc     uint64  `json:"heap_alloc_bytes"`
+	HeapObjects   uint64  `json:"heap_objects"`
+	NumGC         uint32  `json:"num_gc"`
+}
+
+func runtimeStatus() runtimeJSON {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return runtimeJSON{
+		GoVersion:     runtime.Version(),
+		Goroutines:    runtime.NumGoroutine(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		NumCPU:        runtime.NumCPU(),
+		UptimeSeconds: time.Since(started).Seconds(),
+		HeapAlloc:     m.HeapAlloc,
+		HeapObjects:   m.HeapObjects,
+		NumGC:         m.NumGC,
+	}
+}
+
+func writeJSON(w *response.Writer, status response.StatusCode, v any) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Println("Couldn't encode admin response:", err)
+		response.WriteError(w, response.InternalServerError, "")
+		return
+	}
+	h := response.GetDefaultHeaders(0)
+	h.Set("Content-Type", "application/json")
+	writeText(w, status, h, string(body)+"\n")
+}
+
+// writeText sends body with h, filling in its length.
+func writeText(w *response.Writer, status response.StatusCode, h headers.Headers, body string) {
+	h.Set("Content-Length", fmt.Sprint(len(body)))
+	w.WriteStatusLine(status)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body))
+}
+
+func methodNotAllowed(w *response.Writer, allow string) {
+	h := response.GetDefaultHeaders(0)
+	h.Set("Allow", allow)
+	writeText(w, response.MethodNotAllowed, h, "405 Method Not Allowed\n")
+}
@@ -0,0 +1,209 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/server"
+	"github.com/felixsolom/http-from-tcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const token = "s3cret"
+
+func get(t *testing.T, h server.Handler, method, target string) *response.Response {
+	req := servertest.NewRequest(method, target, nil)
+	req.Headers["authorization"] = "Bearer " + token
+	res, err := servertest.Record(h, req)
+	require.NoError(t, err)
+	return res
+}
+
+// busyServer serves requests that wait for release.
+func busyServer(t *testing.T) (s *server.Server, started, release chan struct{}) {
+	started = make(chan struct{}, 1)
+	release = make(chan struct{})
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		started <- struct{}{}
+		<-release
+		response.WriteError(w, response.OK, "ok")
+	}, server.WithLocalhostOnly())
+	require.NoError(t, err)
+	return s, started, release
+}
+
+func TestAuth(t *testing.T) {
+	s, _, _ := busyServer(t)
+	defer s.Close()
+	h := Handler(s, token)
+
+	// Test: Missing or wrong tokens are refused
+	for _, auth := range []string{"", "Bearer wrong", "Basic " + token, token} {
+		req := servertest.NewRequest("GET", "/config", nil)
+		if auth != "" {
+			req.Headers["authorization"] = auth
+		}
+		res, err := servertest.Record(h, req)
+		require.NoError(t, err)
+		assert.Equal(t, response.Unauthorized, res.StatusLine.StatusCode, auth)
+		assert.Equal(t, `Bearer realm="admin"`, res.Headers["www-authenticate"])
+	}
+
+	// Test: An empty token refuses everyone
+	req := servertest.NewRequest("GET", "/config", nil)
+	req.Headers["authorization"] = "Bearer "
+	res, err := servertest.Record(Handler(s, ""), req)
+	require.NoError(t, err)
+	assert.Equal(t, response.Unauthorized, res.StatusLine.StatusCode)
+	_, err = Serve(0, s, "")
+	assert.ErrorIs(t, err, ErrNoToken)
+
+	// Test: The right one gets in, unknown paths are 404s
+	assert.Equal(t, response.OK, get(t, h, "GET", "/config").StatusLine.StatusCode)
+	assert.Equal(t, response.NotFound, get(t, h, "GET", "/nope").StatusLine.StatusCode)
+	assert.Equal(t, response.MethodNotAllowed, get(t, h, "GET", "/drain").StatusLine.StatusCode)
+}
+
+func TestConnsAndConfig(t *testing.T) {
+	s, started, release := busyServer(t)
+	defer s.Close()
+	defer close(release)
+	h := Handler(s, token)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprint(conn, "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi")
+	<-started
+
+	// Test: The connection and its request are listed
+	var conns []struct {
+		RemoteAddr string  `json:"remote_addr"`
+		State      string  `json:"state"`
+		BytesIn    uint64  `json:"bytes_in"`
+		AgeSeconds float64 `json:"age_seconds"`
+		Requests   []struct {
+			Method string `json:"method"`
+			Target string `json:"target"`
+		} `json:"requests"`
+	}
+	res := get(t, h, "GET", "/conns")
+	assert.Equal(t, "application/json", res.Headers["content-type"])
+	require.NoError(t, json.Unmarshal(res.Body, &conns))
+	require.Len(t, conns, 1)
+	assert.Equal(t, conn.LocalAddr().String(), conns[0].RemoteAddr)
+	assert.Equal(t, "active", conns[0].State)
+	assert.NotZero(t, conns[0].BytesIn)
+	assert.GreaterOrEqual(t, conns[0].AgeSeconds, 0.0)
+	require.Len(t, conns[0].Requests, 1)
+	assert.Equal(t, "POST", conns[0].Requests[0].Method)
+	assert.Equal(t, "/upload", conns[0].Requests[0].Target)
+
+	// Test: Config and stats of the server
+	var config struct {
+		Addr  string `json:"addr"`
+		TLS   bool   `json:"tls"`
+		Stats struct {
+			ActiveConns int64 `json:"active_conns"`
+		} `json:"stats"`
+	}
+	require.NoError(t, json.Unmarshal(get(t, h, "GET", "/config").Body, &config))
+	assert.Equal(t, s.Addr().String(), config.Addr)
+	assert.False(t, config.TLS)
+	assert.Equal(t, int64(1), config.Stats.ActiveConns)
+
+	// Test: Runtime numbers
+	var status struct {
+		Goroutines int    `json:"goroutines"`
+		GoVersion  string `json:"go_version"`
+	}
+	require.NoError(t, json.Unmarshal(get(t, h, "GET", "/runtime").Body, &status))
+	assert.Positive(t, status.Goroutines)
+	assert.NotEmpty(t, status.GoVersion)
+}
+
+func TestPprof(t *testing.T) {
+	h := Handler(nil, token)
+
+	// Test: The index lists profiles
+	res := get(t, h, "GET", "/debug/pprof/")
+	assert.Equal(t, response.OK, res.StatusLine.StatusCode)
+	assert.Contains(t, string(res.Body), "/debug/pprof/goroutine")
+
+	// Test: Binary and text profiles
+	res = get(t, h, "GET", "/debug/pprof/heap")
+	assert.Equal(t, "application/octet-stream", res.Headers["content-type"])
+	assert.NotEmpty(t, res.Body)
+	res = get(t, h, "GET", "/debug/pprof/goroutine?debug=1")
+	assert.Contains(t, string(res.Body), "goroutine profile:")
+
+	// Test: A short CPU profile
+	res = get(t, h, "GET", "/debug/pprof/profile?seconds=1")
+	assert.Equal(t, response.OK, res.StatusLine.StatusCode)
+	assert.NotEmpty(t, res.Body)
+
+	// Test: Bad requests
+	assert.Equal(t, response.NotFound, get(t, h, "GET", "/debug/pprof/nope").StatusLine.StatusCode)
+	assert.Equal(t, response.BadRequest, get(t, h, "GET", "/debug/pprof/profile?seconds=0").StatusLine.StatusCode)
+}
+
+func TestDrain(t *testing.T) {
+	s, started, release := busyServer(t)
+	defer s.Close()
+	drained := make(chan struct{})
+	a, err := Serve(0, s, token, WithDrainTimeout(5*time.Second), WithOnDrained(func() { close(drained) }))
+	require.NoError(t, err)
+	defer a.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+
+	// Test: Drain is accepted over the admin listener
+	adminConn, err := net.Dial("tcp", a.Addr().String())
+	require.NoError(t, err)
+	defer adminConn.Close()
+	fmt.Fprintf(adminConn, "POST /drain HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer %s\r\n\r\n", token)
+	raw, err := io.ReadAll(adminConn)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "HTTP/1.1 202 Accepted\r\n")
+
+	// Test: A second drain is refused
+	adminConn, err = net.Dial("tcp", a.Addr().String())
+	require.NoError(t, err)
+	defer adminConn.Close()
+	fmt.Fprintf(adminConn, "POST /drain HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer %s\r\n\r\n", token)
+	raw, err = io.ReadAll(adminConn)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "HTTP/1.1 409 Conflict\r\n")
+
+	// Test: The server stops accepting but finishes the request in flight
+	require.Eventually(t, func() bool {
+		c, err := net.Dial("tcp", s.Addr().String())
+		if err == nil {
+			c.Close()
+		}
+		return err != nil
+	}, time.Second, 5*time.Millisecond)
+	close(release)
+	raw, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "HTTP/1.1 200 OK\r\n")
+	assert.Eventually(t, func() bool { return len(s.Conns()) == 0 }, time.Second, 5*time.Millisecond)
+
+	// Test: Whoever started the server hears that the drain is over
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("drain didn't finish")
+	}
+}
@@ -0,0 +1,105 @@
+package admin
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/url"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+)
+
+const pprofPrefix = "/debug/pprof/"
+
+// maxProfileDuration keeps a CPU profile or trace from tying up the
+// profiler, which only runs one at a time.
+const maxProfileDuration = 60 * time.Second
+
+// pprof serves what net/http/pprof does, in the formats go tool pprof and
+// go tool trace read.
+func (a *admin) pprof(w *response.Writer, req *request.Request, name string) {
+	_, rawQuery, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		response.WriteError(w, response.BadRequest, "bad query string")
+		return
+	}
+
+	var buf bytes.Buffer
+	switch name {
+	case "":
+		writeText(w, response.OK, response.GetDefaultHeaders(0), pprofIndex())
+		return
+	case "profile", "trace":
+		seconds, err := strconv.Atoi(query.Get("seconds"))
+		if query.Get("seconds") == "" {
+			seconds, err = 30, nil
+		}
+		d := time.Duration(seconds) * time.Second
+		if err != nil || d <= 0 || d > maxProfileDuration {
+			response.WriteError(w, response.BadRequest, fmt.Sprintf("seconds must be between 1 and %d", int(maxProfileDuration.Seconds())))
+			return
+		}
+		if name == "profile" {
+			err = pprof.StartCPUProfile(&buf)
+		} else {
+			err = trace.Start(&buf)
+		}
+		if err != nil {
+			// another profile is running
+			response.WriteError(w, response.ServiceUnavailable, err.Error())
+			return
+		}
+		select {
+		case <-time.After(d):
+		case <-req.Context().Done():
+		}
+		if name == "profile" {
+			pprof.StopCPUProfile()
+		} else {
+			trace.Stop()
+		}
+		if req.Context().Err() != nil {
+			return
+		}
+	default:
+		p := pprof.Lookup(name)
+		if p == nil {
+			response.WriteError(w, response.NotFound, "unknown profile")
+			return
+		}
+		debug, _ := strconv.Atoi(query.Get("debug"))
+		if err := p.WriteTo(&buf, debug); err != nil {
+			log.Printf("Couldn't write %s profile: %v", name, err)
+			response.WriteError(w, response.InternalServerError, "")
+			return
+		}
+		if debug > 0 {
+			writeText(w, response.OK, response.GetDefaultHeaders(0), buf.String())
+			return
+		}
+	}
+
+	h := response.GetDefaultHeaders(0)
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	writeText(w, response.OK, h, buf.String())
+}
+
+func pprofIndex() string {
+	var b strings.Builder
+	b.WriteString("Profiles:\n")
+	for _, p := range pprof.Profiles() {
+		fmt.Fprintf(&b, "  %s%s (%d)\n", pprofPrefix, p.Name(), p.Count())
+	}
+	fmt.Fprintf(&b, "  %sprofile?seconds=30\n", pprofPrefix)
+	fmt.Fprintf(&b, "  %strace?seconds=5\n", pprofPrefix)
+	b.WriteString("\nAdd ?debug=1 to the named profiles for text.\n")
+	return b.String()
+}
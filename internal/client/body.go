@@ -0,0 +1,180 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+)
+
+const maxChunkLineLength = 4096
+
+// chunkedReader decodes the chunked transfer-coding and collects the
+// trailer section into trailers once the last chunk is read.
+type chunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	trailers  headers.Headers
+	done      bool
+	err       error
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if cr.done {
+		return 0, io.EOF
+	}
+
+	if cr.remaining == 0 {
+		size, err := cr.readChunkSize()
+		if err != nil {
+			cr.err = err
+			return 0, err
+		}
+		if size == 0 {
+			if err := readHeaderBlock(cr.r, cr.trailers); err != nil {
+				cr.err = fmt.Errorf("couldn't read trailers: %w", err)
+				return 0, cr.err
+			}
+			cr.done = true
+			return 0, io.EOF
+		}
+		cr.remaining = size
+	}
+
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.r.Read(p)
+	cr.remaining -= int64(n)
+	if cr.remaining == 0 && err == nil {
+		err = cr.readCRLF()
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		cr.err = err
+	}
+	return n, err
+}
+
+func (cr *chunkedReader) readChunkSize() (int64, error) {
+	line, err := readLine(cr.r)
+	if err != nil {
+		return 0, err
+	}
+	return response.ParseChunkSize(line)
+}
+
+func (cr *chunkedReader) readCRLF() error {
+	line, err := readLine(cr.r)
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return fmt.Errorf("missing CRLF after chunk data")
+	}
+	return nil
+}
+
+// readLine reads a CRLF (or bare LF) terminated line without the terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) || len(line) > maxChunkLineLength {
+		return "", fmt.Errorf("line too long")
+	}
+	if err != nil {
+		if err == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// readHeaderBlock parses field lines with headers.Parse until the empty line.
+func readHeaderBlock(r *bufio.Reader, h headers.Headers) error {
+	for {
+		line, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return fmt.Errorf("header line too long")
+		}
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		_, done, err := h.Parse(line)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// body is what a Response hands out. Reading it to the end gives the
+// connection back to the pool, closing it early throws the connection away
+// unless the rest is small enough to drain.
+type body struct {
+	r io.Reader
+	// release is called exactly once, with whether the body was consumed
+	// cleanly up to its end
+	release func(clean bool)
+	// mapErr, if set, rewrites read errors other than io.EOF
+	mapErr func(error) error
+
+	mu       sync.Mutex
+	released bool
+	closed   bool
+}
+
+const maxDrainSize = 64 << 10
+
+func (b *body) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, errors.New("read on closed response body")
+	}
+	n, err := b.r.Read(p)
+	if err != nil {
+		b.releaseOnce(err == io.EOF)
+		if err != io.EOF && b.mapErr != nil {
+			err = b.mapErr(err)
+		}
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	if !b.released {
+		n, err := io.CopyN(io.Discard, b.r, maxDrainSize)
+		b.releaseOnce(err == io.EOF && n < maxDrainSize)
+	}
+	return nil
+}
+
+func (b *body) releaseOnce(clean bool) {
+	if b.released {
+		return
+	}
+	b.released = true
+	b.release(clean)
+}
@@ -0,0 +1,315 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+)
+
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+const (
+	defaultMaxRedirects        = 10
+	defaultMaxIdleConnsPerHost = 2
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// Client is an HTTP/1.1 client that speaks the wire protocol itself and keeps
+// connections alive between requests. The zero value is ready to use.
+type Client struct {
+	// DialTimeout bounds connecting, including the TLS handshake.
+	DialTimeout time.Duration
+	// ResponseHeaderTimeout bounds the wait for the status line and the
+	// headers once the request has been written.
+	ResponseHeaderTimeout time.Duration
+	// Timeout bounds the whole exchange, redirects and reading the body
+	// included.
+	Timeout time.Duration
+	// MaxRedirects defaults to 10, a negative value hands redirects back
+	// to the caller instead of following them.
+	MaxRedirects        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	TLSConfig           *tls.Config
+
+	poolOnce sync.Once
+	pool     *connPool
+}
+
+func (c *Client) connPool() *connPool {
+	c.poolOnce.Do(func() {
+		maxIdle := c.MaxIdleConnsPerHost
+		if maxIdle <= 0 {
+			maxIdle = defaultMaxIdleConnsPerHost
+		}
+		idleTimeout := c.IdleConnTimeout
+		if idleTimeout <= 0 {
+			idleTimeout = defaultIdleConnTimeout
+		}
+		c.pool = newConnPool(maxIdle, idleTimeout)
+	})
+	return c.pool
+}
+
+// CloseIdleConnections closes the keep-alive connections nobody is using.
+func (c *Client) CloseIdleConnections() {
+	c.connPool().closeIdle()
+}
+
+func (c *Client) Get(ctx context.Context, rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(ctx, req)
+}
+
+// Do sends req and returns the response once its headers are in. The caller
+// has to close the body, reading it to the end lets the connection be reused.
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	cancel := context.CancelFunc(func() {})
+	if c.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+	}
+
+	maxRedirects := c.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+
+	for redirects := 0; ; redirects++ {
+		res, err := c.roundTrip(ctx, req)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		res.Request = req
+
+		next := redirectRequest(req, res)
+		if next == nil || maxRedirects < 0 {
+			res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+			return res, nil
+		}
+		res.Body.Close()
+		if redirects >= maxRedirects {
+			cancel()
+			return nil, fmt.Errorf("%w: stopped after %d", ErrTooManyRedirects, redirects)
+		}
+		req = next
+	}
+}
+
+// redirectRequest builds the follow-up request for a redirect response, or
+// returns nil when res isn't one.
+func redirectRequest(req *Request, res *Response) *Request {
+	switch res.StatusCode {
+	case 301, 302, 303, 307, 308:
+	default:
+		return nil
+	}
+	location := res.Header("Location")
+	if location == "" {
+		return nil
+	}
+	target, err := req.URL.Parse(location)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return nil
+	}
+
+	next := &Request{
+		Method:  req.Method,
+		URL:     target,
+		Headers: headers.NewHeaders(),
+		Body:    req.Body,
+	}
+	for key, value := range req.Headers {
+		next.Headers[key] = value
+	}
+	next.Headers.Delete("Host")
+
+	// 301 and 302 turn POST into GET for historical reasons, 303 turns
+	// everything but HEAD into GET, 307 and 308 never change the method
+	if (res.StatusCode == 303 && req.Method != "HEAD") ||
+		((res.StatusCode == 301 || res.StatusCode == 302) && req.Method == "POST") {
+		next.Method = "GET"
+		next.Body = nil
+		next.Headers.Delete("Content-Type")
+	}
+	if !strings.EqualFold(target.Host, req.URL.Host) {
+		next.Headers.Delete("Authorization")
+		next.Headers.Delete("Cookie")
+	}
+	return next
+}
+
+func (c *Client) roundTrip(ctx context.Context, req *Request) (*Response, error) {
+	key := req.URL.Scheme + "://" + hostPort(req)
+	pool := c.connPool()
+
+	for attempt := 0; ; attempt++ {
+		pc := pool.get(key)
+		if pc == nil {
+			conn, err := c.dial(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			pc = &persistConn{conn: conn, br: bufio.NewReader(conn), bw: bufio.NewWriter(conn), key: key}
+		}
+
+		deadline, _ := ctx.Deadline()
+		pc.conn.SetDeadline(deadline)
+		// cancelling the context unblocks whatever read or write is in
+		// progress by moving the deadline into the past
+		stop := context.AfterFunc(ctx, func() { pc.conn.SetDeadline(time.Unix(1, 0)) })
+
+		res, err := c.exchange(pc, req, deadline)
+		if err != nil {
+			stop()
+			pc.conn.Close()
+			if ctxErr := contextErr(ctx, deadline, err); ctxErr != nil {
+				return nil, ctxErr
+			}
+			// the server may have closed an idle connection just as we
+			// picked it up, which is worth one more try on a fresh one
+			if pc.reused && attempt == 0 && req.isIdempotent() && isStaleConnErr(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		reader, keepAlive, err := res.bodyReader(pc.br, req.Method)
+		if err != nil {
+			stop()
+			pc.conn.Close()
+			return nil, err
+		}
+		if connection, exists := req.Headers.Get("Connection"); exists && strings.Contains(connection, "close") {
+			keepAlive = false
+		}
+		res.Body = &body{
+			r: reader,
+			release: func(clean bool) {
+				// if the context fired meanwhile the deadline is poisoned
+				if stop() && clean && keepAlive {
+					pool.put(pc)
+					return
+				}
+				pc.conn.Close()
+			},
+			mapErr: func(err error) error {
+				if ctxErr := contextErr(ctx, deadline, err); ctxErr != nil {
+					return ctxErr
+				}
+				return err
+			},
+		}
+		return res, nil
+	}
+}
+
+// contextErr wraps err in the context's error when ctx ending caused it, and
+// returns nil otherwise. The socket deadline is the context's, so a read can
+// time out a moment before ctx itself reports that it expired.
+func contextErr(ctx context.Context, deadline time.Time, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %v", ctx.Err(), err)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) && !deadline.IsZero() && !time.Now().Before(deadline) {
+		return fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+	}
+	return nil
+}
+
+// exchange writes req and reads the response head, skipping interim 1xx
+// responses.
+func (c *Client) exchange(pc *persistConn, req *Request, deadline time.Time) (*Response, error) {
+	if err := req.write(pc.bw); err != nil {
+		return nil, fmt.Errorf("couldn't write request: %w", err)
+	}
+
+	if c.ResponseHeaderTimeout > 0 {
+		headerDeadline := time.Now().Add(c.ResponseHeaderTimeout)
+		if deadline.IsZero() || headerDeadline.Before(deadline) {
+			pc.conn.SetReadDeadline(headerDeadline)
+		}
+	}
+	for {
+		res, err := readResponseHead(pc.br)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode >= 200 || res.StatusCode == 101 {
+			pc.conn.SetReadDeadline(deadline)
+			return res, nil
+		}
+	}
+}
+
+func (c *Client) dial(ctx context.Context, req *Request) (net.Conn, error) {
+	dialCtx := ctx
+	if c.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, c.DialTimeout)
+		defer cancel()
+	}
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(dialCtx, "tcp", hostPort(req))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to %s: %w", req.URL.Host, err)
+	}
+	if req.URL.Scheme != "https" {
+		return conn, nil
+	}
+
+	config := &tls.Config{}
+	if c.TLSConfig != nil {
+		config = c.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = req.URL.Hostname()
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls handshake with %s failed: %w", req.URL.Host, err)
+	}
+	return tlsConn, nil
+}
+
+func hostPort(req *Request) string {
+	if req.URL.Port() != "" {
+		return req.URL.Host
+	}
+	if req.URL.Scheme == "https" {
+		return net.JoinHostPort(req.URL.Hostname(), "443")
+	}
+	return net.JoinHostPort(req.URL.Hostname(), "80")
+}
+
+func isStaleConnErr(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || strings.Contains(err.Error(), "connection reset")
+}
+
+// cancelOnClose releases the timeout context of Do once the body is done.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
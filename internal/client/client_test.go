@@ -0,0 +1,243 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawServer answers every connection with the same canned bytes and closes it.
+func rawServer(t *testing.T, raw string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				for {
+					line, err := br.ReadString('\n')
+					if err != nil || line == "\r\n" {
+						break
+					}
+				}
+				c.Write([]byte(raw))
+			}(conn)
+		}
+	}()
+	return "http://" + l.Addr().String()
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Write([]byte("echo:" + string(body)))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	c := &Client{}
+	for i := 0; i < 3; i++ {
+		req, err := NewRequest("POST", server.URL+"/echo", []byte("hi"))
+		require.NoError(t, err)
+		res, err := c.Do(context.Background(), req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "OK", res.Reason)
+		assert.Equal(t, "POST", res.Header("X-Method"))
+		assert.Equal(t, "echo:hi", string(body))
+		assert.Equal(t, int64(7), res.ContentLength)
+	}
+	// Test: All three requests went over a single connection
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+func TestClientBodyFraming(t *testing.T) {
+	// Test: Chunked body with extensions and trailers
+	url := rawServer(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5;ext=1\r\nhello\r\n7\r\n, world\r\n0\r\nX-Checksum: abc\r\n\r\n")
+	res, err := (&Client{}).Get(context.Background(), url)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "hello, world", string(body))
+	assert.Equal(t, int64(-1), res.ContentLength)
+	assert.Equal(t, "abc", res.Trailers["x-checksum"])
+
+	// Test: Body delimited by the connection closing
+	url = rawServer(t, "HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil the end")
+	res, err = (&Client{}).Get(context.Background(), url)
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "until the end", string(body))
+
+	// Test: Interim responses are skipped
+	url = rawServer(t, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\n"+
+		"HTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok")
+	res, err = (&Client{}).Get(context.Background(), url)
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 201, res.StatusCode)
+	assert.Equal(t, "ok", string(body))
+
+	// Test: A 101 response's connection is never pooled, even when it
+	// asks to be kept alive
+	url = rawServer(t, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade, keep-alive\r\nUpgrade: websocket\r\n\r\n")
+	c := &Client{}
+	res, err = c.Get(context.Background(), url)
+	require.NoError(t, err)
+	_, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 101, res.StatusCode)
+	assert.Empty(t, c.connPool().idle)
+
+	// Test: Connection closed before Content-Length bytes arrived
+	url = rawServer(t, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort")
+	res, err = (&Client{}).Get(context.Background(), url)
+	require.NoError(t, err)
+	_, err = io.ReadAll(res.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	res.Body.Close()
+
+	// Test: Malformed status line
+	url = rawServer(t, "HTTP/2 200 OK\r\n\r\n")
+	_, err = (&Client{}).Get(context.Background(), url)
+	require.Error(t, err)
+}
+
+func TestClientRedirects(t *testing.T) {
+	var seenMethod, seenBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/found":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/temporary":
+			http.Redirect(w, r, "/final", http.StatusTemporaryRedirect)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/final":
+			body, _ := io.ReadAll(r.Body)
+			seenMethod, seenBody = r.Method, string(body)
+			w.Write([]byte("final"))
+		}
+	}))
+	defer server.Close()
+	c := &Client{}
+
+	// Test: 302 turns POST into a bodyless GET
+	req, _ := NewRequest("POST", server.URL+"/found", []byte("data"))
+	res, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "/final", res.Request.URL.Path)
+	assert.Equal(t, "GET", seenMethod)
+	assert.Equal(t, "", seenBody)
+
+	// Test: 307 keeps the method and body
+	req, _ = NewRequest("POST", server.URL+"/temporary", []byte("data"))
+	res, err = c.Do(context.Background(), req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "POST", seenMethod)
+	assert.Equal(t, "data", seenBody)
+
+	// Test: Redirect loops give up
+	_, err = c.Get(context.Background(), server.URL+"/loop")
+	assert.ErrorIs(t, err, ErrTooManyRedirects)
+
+	// Test: Redirects can be handed back to the caller
+	res, err = (&Client{MaxRedirects: -1}).Get(context.Background(), server.URL+"/found")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 302, res.StatusCode)
+	assert.Equal(t, "/final", res.Header("Location"))
+}
+
+func TestClientTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/partial" {
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+		}
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	// Test: Response header timeout
+	_, err := (&Client{ResponseHeaderTimeout: 20 * time.Millisecond}).Get(context.Background(), server.URL)
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+
+	// Test: Overall timeout surfaces as a deadline
+	_, err = (&Client{Timeout: 20 * time.Millisecond}).Get(context.Background(), server.URL)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Test: Overall timeout while reading the body surfaces as a deadline
+	res, err := (&Client{Timeout: 200 * time.Millisecond}).Get(context.Background(), server.URL+"/partial")
+	require.NoError(t, err)
+	_, err = io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Test: Cancelled context
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = (&Client{}).Get(ctx, server.URL)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRequestWrite(t *testing.T) {
+	req, err := NewRequest("PUT", "http://example.com:8080/things?id=1", []byte("body"))
+	require.NoError(t, err)
+	req.Headers.Set("Content-Type", "text/plain")
+	req.Headers.Set("Content-Length", "999")
+
+	var sb strings.Builder
+	bw := bufio.NewWriter(&sb)
+	require.NoError(t, req.write(bw))
+	raw := sb.String()
+
+	assert.True(t, strings.HasPrefix(raw, "PUT /things?id=1 HTTP/1.1\r\n"))
+	assert.Contains(t, raw, "Host: example.com:8080\r\n")
+	assert.Contains(t, raw, "Content-Length: 4\r\n")
+	assert.NotContains(t, raw, "999")
+	assert.Contains(t, raw, "Content-Type: text/plain\r\n")
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\nbody"))
+}
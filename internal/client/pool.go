@@ -0,0 +1,79 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// persistConn is a connection that may outlive a single exchange.
+type persistConn struct {
+	conn      net.Conn
+	br        *bufio.Reader
+	bw        *bufio.Writer
+	key       string
+	reused    bool
+	idleSince time.Time
+}
+
+// connPool keeps idle keep-alive connections per scheme and host.
+type connPool struct {
+	mu             sync.Mutex
+	idle           map[string][]*persistConn
+	maxIdlePerHost int
+	idleTimeout    time.Duration
+}
+
+func newConnPool(maxIdlePerHost int, idleTimeout time.Duration) *connPool {
+	return &connPool{
+		idle:           map[string][]*persistConn{},
+		maxIdlePerHost: maxIdlePerHost,
+		idleTimeout:    idleTimeout,
+	}
+}
+
+// get hands out the most recently used idle connection for key, closing the
+// ones that sat idle for too long on the way.
+func (p *connPool) get(key string) *persistConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conns := p.idle[key]
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(pc.idleSince) > p.idleTimeout {
+			pc.conn.Close()
+			continue
+		}
+		p.idle[key] = conns
+		pc.reused = true
+		return pc
+	}
+	delete(p.idle, key)
+	return nil
+}
+
+func (p *connPool) put(pc *persistConn) {
+	pc.conn.SetDeadline(time.Time{})
+	pc.idleSince = time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle[pc.key]) >= p.maxIdlePerHost {
+		pc.conn.Close()
+		return
+	}
+	p.idle[pc.key] = append(p.idle[pc.key], pc)
+}
+
+func (p *connPool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, conns := range p.idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+		delete(p.idle, key)
+	}
+}
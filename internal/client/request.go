@@ -0,0 +1,81 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+)
+
+const userAgent = "http-from-tcp"
+
+type Request struct {
+	Method  string
+	URL     *url.URL
+	Headers headers.Headers
+	Body    []byte
+}
+
+func NewRequest(method, rawURL string, body []byte) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse url %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in url: %q", rawURL)
+	}
+	return &Request{
+		Method:  method,
+		URL:     u,
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}, nil
+}
+
+// write serializes the request in HTTP/1.1 wire format. Host, Content-Length
+// and User-Agent are filled in unless the headers already carry them, and
+// hop-by-hop framing headers are replaced since the body is always sent with
+// a Content-Length.
+func (r *Request) write(w *bufio.Writer) error {
+	target := r.URL.RequestURI()
+	if r.Method == "CONNECT" {
+		target = r.URL.Host
+	}
+	fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", r.Method, target)
+
+	if _, exists := r.Headers.Lookup("Host"); !exists {
+		fmt.Fprintf(w, "Host: %s\r\n", r.URL.Host)
+	}
+	if _, exists := r.Headers.Lookup("User-Agent"); !exists {
+		fmt.Fprintf(w, "User-Agent: %s\r\n", userAgent)
+	}
+	if len(r.Body) > 0 || methodExpectsBody(r.Method) {
+		fmt.Fprintf(w, "Content-Length: %d\r\n", len(r.Body))
+	}
+	for key, value := range r.Headers {
+		if strings.EqualFold(key, "Content-Length") || strings.EqualFold(key, "Transfer-Encoding") {
+			continue
+		}
+		fmt.Fprintf(w, "%s: %s\r\n", key, value)
+	}
+	w.WriteString("\r\n")
+	w.Write(r.Body)
+	return w.Flush()
+}
+
+func methodExpectsBody(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
+
+func (r *Request) isIdempotent() bool {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+)
+
+type Response struct {
+	Proto      string
+	StatusCode int
+	Reason     string
+	Headers    headers.Headers
+	// Trailers are filled in once a chunked Body has been read to the end.
+	Trailers headers.Headers
+	// ContentLength is -1 when the length isn't known up front.
+	ContentLength int64
+	Body          io.ReadCloser
+	// Request is the request that produced this response, which differs
+	// from the one passed to Do when redirects were followed.
+	Request *Request
+}
+
+// Header returns the value of key, unlike headers.Headers.Get without
+// lowercasing it.
+func (r *Response) Header(key string) string {
+	value, _ := r.Headers.Lookup(key)
+	return value
+}
+
+func readResponseHead(br *bufio.Reader) (*Response, error) {
+	line, err := readLine(br)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read status line: %w", err)
+	}
+	res, err := parseStatusLine(line)
+	if err != nil {
+		return nil, err
+	}
+	res.Headers = headers.NewHeaders()
+	res.Trailers = headers.NewHeaders()
+	if err := readHeaderBlock(br, res.Headers); err != nil {
+		return nil, fmt.Errorf("couldn't read response headers: %w", err)
+	}
+	return res, nil
+}
+
+func parseStatusLine(line string) (*Response, error) {
+	statusLine, err := response.ParseStatusLine(line)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		Proto:         "HTTP/" + statusLine.HttpVersion,
+		StatusCode:    int(statusLine.StatusCode),
+		Reason:        statusLine.ReasonPhrase,
+		ContentLength: -1,
+	}, nil
+}
+
+// bodyReader works out the framing of the response body. keepAlive reports
+// whether the connection can carry another request once the body is read.
+func (r *Response) bodyReader(br *bufio.Reader, method string) (reader io.Reader, keepAlive bool, err error) {
+	keepAlive = r.Proto == "HTTP/1.1"
+	if connection, exists := r.Headers.Get("Connection"); exists {
+		for _, token := range strings.Split(connection, ",") {
+			switch strings.TrimSpace(token) {
+			case "close":
+				keepAlive = false
+			case "keep-alive":
+				keepAlive = true
+			}
+		}
+	}
+	// after a 101 the connection speaks another protocol, never HTTP again
+	if r.StatusCode == 101 {
+		keepAlive = false
+	}
+
+	framing, length, err := response.Framing(response.StatusCode(r.StatusCode), r.Headers)
+	if err != nil {
+		return nil, false, err
+	}
+	if method == "HEAD" {
+		framing = response.FramingNone
+	}
+	switch framing {
+	case response.FramingNone:
+		// the length of the body there would have been, if any
+		if contentLength, exists := r.Headers.Get("Content-Length"); exists {
+			r.ContentLength, _ = strconv.ParseInt(strings.TrimSpace(contentLength), 10, 64)
+		}
+		return strings.NewReader(""), keepAlive, nil
+	case response.FramingChunked:
+		return &chunkedReader{r: br, trailers: r.Trailers}, keepAlive, nil
+	case response.FramingLength:
+		r.ContentLength = length
+		return &exactReader{r: io.LimitReader(br, length), remaining: length}, keepAlive, nil
+	}
+	return br, false, nil
+}
+
+// exactReader is a LimitReader that complains when the connection ends
+// before the declared length was read.
+type exactReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (er *exactReader) Read(p []byte) (int, error) {
+	if er.remaining == 0 {
+		return 0, io.EOF
+	}
+	n, err := er.r.Read(p)
+	er.remaining -= int64(n)
+	if err == io.EOF && er.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && er.remaining == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
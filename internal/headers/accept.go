@@ -0,0 +1,249 @@
+package headers
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrNotAcceptable = errors.New("none of the offers is acceptable")
+
+type acceptKind int
+
+const (
+	acceptMediaType acceptKind = iota
+	acceptLanguage
+	acceptCharset
+	acceptEncoding
+)
+
+// Preference is a single element of an Accept-* header.
+type Preference struct {
+	Value  string
+	Q      float64
+	Params map[string]string
+}
+
+// Preferences is a parsed Accept-* header, ordered from the most to the least
+// preferred element.
+type Preferences struct {
+	kind  acceptKind
+	items []Preference
+	// absent is set when the header was not sent at all, which means
+	// anything goes
+	absent bool
+}
+
+func ParseAccept(value string) Preferences {
+	return parsePreferences(acceptMediaType, value)
+}
+
+func ParseAcceptLanguage(value string) Preferences {
+	return parsePreferences(acceptLanguage, value)
+}
+
+func ParseAcceptCharset(value string) Preferences {
+	return parsePreferences(acceptCharset, value)
+}
+
+func ParseAcceptEncoding(value string) Preferences {
+	return parsePreferences(acceptEncoding, value)
+}
+
+func parsePreferences(kind acceptKind, value string) Preferences {
+	p := Preferences{kind: kind, absent: strings.TrimSpace(value) == ""}
+	for _, element := range splitQuoted(value, ',') {
+		parts := splitQuoted(element, ';')
+		pref := Preference{
+			Value: strings.ToLower(strings.TrimSpace(parts[0])),
+			Q:     1,
+		}
+		if pref.Value == "" {
+			continue
+		}
+		if kind == acceptEncoding && pref.Value == "x-gzip" {
+			pref.Value = "gzip"
+		}
+		for _, param := range parts[1:] {
+			name, paramValue := parseParam(param)
+			if name == "q" {
+				q, err := strconv.ParseFloat(paramValue, 64)
+				if err != nil || q < 0 || q > 1 {
+					q = 0
+				}
+				pref.Q = q
+				// anything after q are accept-ext parameters, not
+				// part of the media range
+				break
+			}
+			if name == "" {
+				continue
+			}
+			if pref.Params == nil {
+				pref.Params = map[string]string{}
+			}
+			pref.Params[name] = paramValue
+		}
+		p.items = append(p.items, pref)
+	}
+
+	sort.SliceStable(p.items, func(i, j int) bool {
+		if p.items[i].Q != p.items[j].Q {
+			return p.items[i].Q > p.items[j].Q
+		}
+		return p.specificity(p.items[i]) > p.specificity(p.items[j])
+	})
+	return p
+}
+
+// Items returns the elements in order of preference.
+func (p Preferences) Items() []Preference {
+	return p.items
+}
+
+// Negotiate returns the offer the client prefers most. Offers are ordered by
+// the server's own preference, which breaks ties between equal q-values.
+func (p Preferences) Negotiate(offers []string) (string, error) {
+	if len(offers) == 0 {
+		return "", ErrNotAcceptable
+	}
+	if p.absent {
+		return offers[0], nil
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q := p.quality(strings.ToLower(offer))
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	if bestQ == 0 {
+		return "", ErrNotAcceptable
+	}
+	return best, nil
+}
+
+// quality finds the q-value of the most specific element matching offer.
+func (p Preferences) quality(offer string) float64 {
+	q, specificity := -1.0, -1
+	for _, pref := range p.items {
+		if !p.matches(pref, offer) {
+			continue
+		}
+		if s := p.specificity(pref); s > specificity {
+			q, specificity = pref.Q, s
+		}
+	}
+	if q >= 0 {
+		return q
+	}
+	// identity is always acceptable unless it was explicitly ruled out,
+	// either by name or through "*;q=0"
+	if p.kind == acceptEncoding && offer == "identity" {
+		return 0.001
+	}
+	return 0
+}
+
+func (p Preferences) matches(pref Preference, offer string) bool {
+	if pref.Value == "*" {
+		return true
+	}
+	switch p.kind {
+	case acceptMediaType:
+		offerType, offerParams, _ := strings.Cut(offer, ";")
+		mainType, subType, _ := strings.Cut(strings.TrimSpace(offerType), "/")
+		prefMain, prefSub, _ := strings.Cut(pref.Value, "/")
+		if prefMain == "*" {
+			return true
+		}
+		if prefMain != mainType {
+			return false
+		}
+		if prefSub == "*" {
+			return true
+		}
+		if prefSub != subType {
+			return false
+		}
+		// every parameter of the media range has to be on the offer
+		if len(pref.Params) == 0 {
+			return true
+		}
+		params := map[string]string{}
+		for _, param := range splitQuoted(offerParams, ';') {
+			name, value := parseParam(param)
+			params[name] = value
+		}
+		for name, value := range pref.Params {
+			if offerValue, ok := params[name]; !ok || offerValue != value {
+				return false
+			}
+		}
+		return true
+	case acceptLanguage:
+		// basic filtering from RFC 4647: "en" matches "en" and "en-us"
+		return offer == pref.Value || strings.HasPrefix(offer, pref.Value+"-")
+	default:
+		return offer == pref.Value
+	}
+}
+
+func (p Preferences) specificity(pref Preference) int {
+	if pref.Value == "*" {
+		return 0
+	}
+	switch p.kind {
+	case acceptMediaType:
+		if pref.Value == "*/*" {
+			return 0
+		}
+		if strings.HasSuffix(pref.Value, "/*") {
+			return 1
+		}
+		return 2 + len(pref.Params)
+	case acceptLanguage:
+		return 1 + strings.Count(pref.Value, "-")
+	default:
+		return 1
+	}
+}
+
+// parseParam splits a name=value parameter, unquoting the value. Both come
+// back lowercased.
+func parseParam(param string) (string, string) {
+	name, value, _ := strings.Cut(param, "=")
+	name = strings.ToLower(strings.TrimSpace(name))
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		var b strings.Builder
+		for i := 1; i < len(value)-1; i++ {
+			if value[i] == '\\' && i+1 < len(value)-1 {
+				i++
+			}
+			b.WriteByte(value[i])
+		}
+		value = b.String()
+	}
+	return name, strings.ToLower(value)
+}
+
+// splitQuoted splits s at sep, except where sep is inside a quoted string.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,97 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccept(t *testing.T) {
+	// Test: Ordered by q-value, then by specificity
+	accept := ParseAccept("text/*;q=0.5, application/json, */*;q=0.1, text/csv;q=0.5")
+	items := accept.Items()
+	require.Len(t, items, 4)
+	assert.Equal(t, "application/json", items[0].Value)
+	assert.Equal(t, "text/csv", items[1].Value)
+	assert.Equal(t, "text/*", items[2].Value)
+	assert.Equal(t, "*/*", items[3].Value)
+	assert.Equal(t, 0.1, items[3].Q)
+
+	// Test: Media range parameters
+	accept = ParseAccept(`text/plain; charset="UTF-8"; q=0.8`)
+	items = accept.Items()
+	require.Len(t, items, 1)
+	assert.Equal(t, map[string]string{"charset": "utf-8"}, items[0].Params)
+	assert.Equal(t, 0.8, items[0].Q)
+
+	// Test: Separators inside quoted parameter values
+	accept = ParseAccept(`text/html; foo="a;b, c\"d"; q=0.5, application/json`)
+	items = accept.Items()
+	require.Len(t, items, 2)
+	assert.Equal(t, "application/json", items[0].Value)
+	assert.Equal(t, map[string]string{"foo": `a;b, c"d`}, items[1].Params)
+	assert.Equal(t, 0.5, items[1].Q)
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"application/json", "text/csv"}
+
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"text/csv", "text/csv"},
+		{"text/*", "text/csv"},
+		{"text/csv;q=0.9, application/json;q=0.8", "text/csv"},
+		{"text/csv, application/json", "application/json"},
+		{"application/*;q=0.2, text/csv;q=0.3", "text/csv"},
+		{"*/*;q=0.5, application/json;q=0", "text/csv"},
+		{"TEXT/CSV", "text/csv"},
+	}
+	for _, tc := range tests {
+		offer, err := ParseAccept(tc.accept).Negotiate(offers)
+		require.NoError(t, err, tc.accept)
+		assert.Equal(t, tc.expected, offer, tc.accept)
+	}
+
+	// Test: Media range parameters are compared whole
+	level, err := ParseAccept("text/html;level=1, text/html;q=0.1").Negotiate([]string{"text/html;level=10", "text/html; level=1"})
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; level=1", level)
+	_, err = ParseAccept("text/html;level=1").Negotiate([]string{"text/html;level=10", `text/html;level="1;x"`})
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+
+	// Test: Nothing acceptable
+	_, err = ParseAccept("image/png").Negotiate(offers)
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+	_, err = ParseAccept("*/*;q=0").Negotiate(offers)
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+
+	// Test: Languages use prefix matching, most specific range wins
+	language, err := ParseAcceptLanguage("en;q=0.5, en-GB;q=0.1, de").Negotiate([]string{"en-GB", "en-US"})
+	require.NoError(t, err)
+	assert.Equal(t, "en-US", language)
+	language, err = ParseAcceptLanguage("fr, *;q=0.1").Negotiate([]string{"de", "fr-CA"})
+	require.NoError(t, err)
+	assert.Equal(t, "fr-CA", language)
+	_, err = ParseAcceptLanguage("fr").Negotiate([]string{"de"})
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+
+	// Test: Charsets
+	charset, err := ParseAcceptCharset("iso-8859-5, utf-8;q=0.8").Negotiate([]string{"utf-8", "iso-8859-5"})
+	require.NoError(t, err)
+	assert.Equal(t, "iso-8859-5", charset)
+
+	// Test: Encodings, with identity acceptable unless ruled out
+	encoding, err := ParseAcceptEncoding("x-gzip;q=0.5, br").Negotiate([]string{"gzip", "deflate"})
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", encoding)
+	encoding, err = ParseAcceptEncoding("br").Negotiate([]string{"gzip", "identity"})
+	require.NoError(t, err)
+	assert.Equal(t, "identity", encoding)
+	_, err = ParseAcceptEncoding("br, *;q=0").Negotiate([]string{"gzip", "identity"})
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+}
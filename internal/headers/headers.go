@@ -76,10 +76,36 @@ func (h Headers) Get(key string) (string, bool) {
 }
 
 func (h Headers) Override(key, value string) {
+	h.Delete(key)
 	key = strings.ToLower(key)
 	h[key] = value
 }
 
+// Set stores value under key as cased, replacing the key in any other casing.
 func (h Headers) Set(key, value string) {
+	h.Delete(key)
 	h[key] = value
 }
+
+// Lookup finds key regardless of how it was cased when set, returning the
+// value unchanged.
+func (h Headers) Lookup(key string) (string, bool) {
+	if value, exists := h[key]; exists {
+		return value, true
+	}
+	for k, value := range h {
+		if strings.EqualFold(k, key) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Delete removes key in every casing it was set with.
+func (h Headers) Delete(key string) {
+	for k := range h {
+		if strings.EqualFold(k, key) {
+			delete(h, k)
+		}
+	}
+}
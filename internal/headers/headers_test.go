@@ -54,3 +54,30 @@ func TestHeadersParse(t *testing.T) {
 	assert.False(t, done)
 
 }
+
+func TestHeadersLookupDelete(t *testing.T) {
+	// Test: Lookup ignores the casing of the key and keeps the value as is
+	headers := NewHeaders()
+	headers.Set("Content-Type", "Text/HTML")
+	value, exists := headers.Lookup("content-type")
+	require.True(t, exists)
+	assert.Equal(t, "Text/HTML", value)
+
+	// Test: Override replaces the value set with another casing
+	headers.Override("Content-Type", "application/json")
+	assert.Len(t, headers, 1)
+	assert.Equal(t, "application/json", headers["content-type"])
+
+	// Test: Set keeps the given casing and replaces other casings
+	headers.Set("CONTENT-TYPE", "text/csv")
+	assert.Len(t, headers, 1)
+	assert.Equal(t, "text/csv", headers["CONTENT-TYPE"])
+
+	// Test: Delete removes every casing
+	headers.Set("Vary", "Accept")
+	headers.Set("VARY", "Origin")
+	headers.Delete("vary")
+	_, exists = headers.Lookup("Vary")
+	assert.False(t, exists)
+	assert.Len(t, headers, 1)
+}
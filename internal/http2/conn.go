@@ -0,0 +1,679 @@
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"golang.org/x/net/http2/hpack"
+)
+
+type Handler func(w *response.Writer, req *request.Request)
+
+const (
+	maxConcurrentStreams = 100
+	maxHeaderListSize    = 1 << 20
+	// maxRequestBodySize bounds what a stream may buffer before its
+	// handler runs. Larger bodies get a 413.
+	maxRequestBodySize = 10 << 20
+	// maxBufferedBodySize bounds the request bodies all streams of a
+	// connection hold before their handlers take them. Streams that would
+	// go past it are refused.
+	maxBufferedBodySize = 32 << 20
+)
+
+// serverConn is one HTTP/2 connection. Frames are read by a single
+// goroutine, each request runs its handler in its own, and writes from all
+// of them are serialized by writeMu.
+type serverConn struct {
+	conn    net.Conn
+	r       io.Reader
+	handler Handler
+	// ctx is cancelled when the connection ends, and with it every
+	// stream's.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// writeMu also guards the HPACK encoder, whose state has to follow
+	// the order header blocks go out in.
+	writeMu sync.Mutex
+	henc    *hpack.Encoder
+	hbuf    bytes.Buffer
+
+	// read loop only
+	hdec         *hpack.Decoder
+	headerStream uint32
+	headerEnd    bool
+	headerBlock  []byte
+	lastStreamID uint32
+
+	// mu guards the streams and flow control windows, cond wakes writers
+	// waiting for window.
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*stream
+	sendWindow        int64
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	closed            bool
+	// buffered is the request body held by all streams together
+	buffered int64
+	// running counts handlers that haven't returned, which a reset
+	// stream's handler only does after it has left streams
+	running int
+
+	handlers sync.WaitGroup
+}
+
+// ServeConn speaks HTTP/2 with prior knowledge on c, reading through r,
+// which may hold bytes already buffered from c and has to start with the
+// client preface. It returns when the connection is done.
+func ServeConn(c net.Conn, r io.Reader, handler Handler) error {
+	sc := newServerConn(c, r, handler)
+	if err := sc.writeSettings(); err != nil {
+		return err
+	}
+	return sc.serve()
+}
+
+// HasPreface tells whether br starts with the HTTP/2 client preface. It
+// only waits for more input while what arrived so far could still be one,
+// so short HTTP/1 requests aren't held up.
+func HasPreface(br *bufio.Reader) bool {
+	for n := 1; n <= len(Preface); n++ {
+		b, err := br.Peek(n)
+		if err != nil || b[n-1] != Preface[n-1] {
+			return false
+		}
+	}
+	return true
+}
+
+func newServerConn(c net.Conn, r io.Reader, handler Handler) *serverConn {
+	sc := &serverConn{
+		conn:              c,
+		r:                 r,
+		handler:           handler,
+		hdec:              hpack.NewDecoder(defaultTableSize, nil),
+		streams:           make(map[uint32]*stream),
+		sendWindow:        defaultWindowSize,
+		peerInitialWindow: defaultWindowSize,
+		peerMaxFrameSize:  defaultMaxFrameSize,
+	}
+	sc.ctx, sc.cancel = context.WithCancel(context.Background())
+	sc.henc = hpack.NewEncoder(&sc.hbuf)
+	sc.hdec.SetMaxStringLength(maxHeaderListSize)
+	sc.cond = sync.NewCond(&sc.mu)
+	return sc
+}
+
+func (sc *serverConn) writeSettings() error {
+	return sc.writeFrame(FrameSettings, 0, 0, encodeSettings([]Setting{
+		{SettingMaxConcurrentStreams, maxConcurrentStreams},
+		{SettingMaxHeaderListSize, maxHeaderListSize},
+	}))
+}
+
+func (sc *serverConn) serve() error {
+	defer sc.shutdown()
+
+	preface := make([]byte, len(Preface))
+	if _, err := io.ReadFull(sc.r, preface); err != nil {
+		return err
+	}
+	if string(preface) != Preface {
+		return errors.New("http2: bad client preface")
+	}
+
+	first := true
+	for {
+		f, err := ReadFrame(sc.r, defaultMaxFrameSize)
+		if err == nil && first && f.Type != FrameSettings {
+			err = connError(ErrCodeProtocol, "first frame is %s, not SETTINGS", f.Type)
+		}
+		first = false
+		if err == nil {
+			err = sc.processFrame(f)
+		}
+		var se StreamError
+		if errors.As(err, &se) {
+			sc.resetStream(se.StreamID, se.Code)
+			continue
+		}
+		var ce ConnectionError
+		if errors.As(err, &ce) {
+			sc.goAway(ce.Code)
+			return ce
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// shutdown fails every writer still waiting for window and waits for the
+// handlers to return.
+func (sc *serverConn) shutdown() {
+	sc.mu.Lock()
+	sc.closed = true
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	sc.cancel()
+	sc.handlers.Wait()
+}
+
+func (sc *serverConn) processFrame(f *Frame) error {
+	if sc.headerStream != 0 && (f.Type != FrameContinuation || f.StreamID != sc.headerStream) {
+		return connError(ErrCodeProtocol, "%s while a header block on stream %d is open", f.Type, sc.headerStream)
+	}
+	switch f.Type {
+	case FrameData:
+		return sc.processData(f)
+	case FrameHeaders:
+		return sc.processHeaders(f)
+	case FramePriority:
+		if f.StreamID == 0 {
+			return connError(ErrCodeProtocol, "PRIORITY on stream 0")
+		}
+		if len(f.Payload) != 5 {
+			return streamError(f.StreamID, ErrCodeFrameSize, "PRIORITY of %d bytes", len(f.Payload))
+		}
+		// priorities are advisory and deprecated, every stream is equal
+		return nil
+	case FrameRSTStream:
+		return sc.processRSTStream(f)
+	case FrameSettings:
+		return sc.processSettings(f)
+	case FramePushPromise:
+		return connError(ErrCodeProtocol, "clients can't push")
+	case FramePing:
+		if f.StreamID != 0 {
+			return connError(ErrCodeProtocol, "PING on stream %d", f.StreamID)
+		}
+		if len(f.Payload) != 8 {
+			return connError(ErrCodeFrameSize, "PING of %d bytes", len(f.Payload))
+		}
+		if f.Flags.Has(FlagAck) {
+			return nil
+		}
+		return sc.writeFrame(FramePing, FlagAck, 0, f.Payload)
+	case FrameGoAway:
+		if f.StreamID != 0 {
+			return connError(ErrCodeProtocol, "GOAWAY on stream %d", f.StreamID)
+		}
+		// the client opens no new streams, the ones it has keep going
+		// until it hangs up
+		return nil
+	case FrameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	case FrameContinuation:
+		if sc.headerStream == 0 {
+			return connError(ErrCodeProtocol, "CONTINUATION without HEADERS")
+		}
+		sc.headerBlock = append(sc.headerBlock, f.Payload...)
+		if len(sc.headerBlock) > maxHeaderListSize {
+			return connError(ErrCodeEnhanceYourCalm, "header block too large")
+		}
+		if f.Flags.Has(FlagEndHeaders) {
+			return sc.endHeaderBlock()
+		}
+		return nil
+	}
+	// unknown frame types must be ignored
+	return nil
+}
+
+func (sc *serverConn) processSettings(f *Frame) error {
+	if f.StreamID != 0 {
+		return connError(ErrCodeProtocol, "SETTINGS on stream %d", f.StreamID)
+	}
+	if f.Flags.Has(FlagAck) {
+		if len(f.Payload) != 0 {
+			return connError(ErrCodeFrameSize, "SETTINGS ACK with payload")
+		}
+		return nil
+	}
+	settings, err := parseSettings(f.Payload)
+	if err != nil {
+		return err
+	}
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	return sc.writeFrame(FrameSettings, FlagAck, 0, nil)
+}
+
+func (sc *serverConn) applySettings(settings []Setting) error {
+	for _, s := range settings {
+		switch s.ID {
+		case SettingHeaderTableSize:
+			sc.writeMu.Lock()
+			sc.henc.SetMaxDynamicTableSizeLimit(s.Value)
+			sc.writeMu.Unlock()
+		case SettingInitialWindowSize:
+			sc.mu.Lock()
+			delta := int64(s.Value) - sc.peerInitialWindow
+			sc.peerInitialWindow = int64(s.Value)
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					sc.mu.Unlock()
+					return connError(ErrCodeFlowControl, "window of stream %d overflows", st.id)
+				}
+			}
+			sc.cond.Broadcast()
+			sc.mu.Unlock()
+		case SettingMaxFrameSize:
+			sc.mu.Lock()
+			sc.peerMaxFrameSize = s.Value
+			sc.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(f *Frame) error {
+	if len(f.Payload) != 4 {
+		return connError(ErrCodeFrameSize, "WINDOW_UPDATE of %d bytes", len(f.Payload))
+	}
+	increment := int64(uint32(f.Payload[0]&0x7f)<<24 | uint32(f.Payload[1])<<16 | uint32(f.Payload[2])<<8 | uint32(f.Payload[3]))
+	if increment == 0 {
+		if f.StreamID == 0 {
+			return connError(ErrCodeProtocol, "WINDOW_UPDATE of 0")
+		}
+		return streamError(f.StreamID, ErrCodeProtocol, "WINDOW_UPDATE of 0")
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if f.StreamID == 0 {
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return connError(ErrCodeFlowControl, "connection window overflows")
+		}
+	} else if st, ok := sc.streams[f.StreamID]; ok {
+		st.sendWindow += increment
+		if st.sendWindow > maxWindowSize {
+			return streamError(f.StreamID, ErrCodeFlowControl, "window overflows")
+		}
+	} else if f.StreamID > sc.lastStreamID {
+		return connError(ErrCodeProtocol, "WINDOW_UPDATE on idle stream %d", f.StreamID)
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(f *Frame) error {
+	if f.StreamID == 0 {
+		return connError(ErrCodeProtocol, "RST_STREAM on stream 0")
+	}
+	if len(f.Payload) != 4 {
+		return connError(ErrCodeFrameSize, "RST_STREAM of %d bytes", len(f.Payload))
+	}
+	if f.StreamID > sc.lastStreamID {
+		return connError(ErrCodeProtocol, "RST_STREAM on idle stream %d", f.StreamID)
+	}
+	sc.mu.Lock()
+	if st, ok := sc.streams[f.StreamID]; ok {
+		st.reset = true
+		st.cancel()
+		sc.releaseBody(st)
+		delete(sc.streams, f.StreamID)
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+	return nil
+}
+
+func (sc *serverConn) processHeaders(f *Frame) error {
+	if f.StreamID == 0 || f.StreamID%2 == 0 {
+		return connError(ErrCodeProtocol, "HEADERS on stream %d", f.StreamID)
+	}
+	sc.headerStream = f.StreamID
+	sc.headerEnd = f.Flags.Has(FlagEndStream)
+	sc.headerBlock = append(sc.headerBlock[:0], f.Payload...)
+	if f.Flags.Has(FlagEndHeaders) {
+		return sc.endHeaderBlock()
+	}
+	return nil
+}
+
+// endHeaderBlock decodes a complete header block, which either opens a
+// stream or carries the trailers of one.
+func (sc *serverConn) endHeaderBlock() error {
+	id := sc.headerStream
+	sc.headerStream = 0
+	// decoding has to happen even for streams we refuse, to keep the
+	// HPACK table in sync with the client's
+	fields, err := sc.hdec.DecodeFull(sc.headerBlock)
+	if err != nil {
+		return connError(ErrCodeCompression, "%v", err)
+	}
+
+	sc.mu.Lock()
+	st, exists := sc.streams[id]
+	// handlers of reset streams count too, or a client could pile them up
+	// by opening and resetting streams in a loop
+	active := max(len(sc.streams), sc.running)
+	sc.mu.Unlock()
+
+	if exists {
+		if st.halfClosed() {
+			return streamError(id, ErrCodeStreamClosed, "HEADERS after end of stream")
+		}
+		if !sc.headerEnd {
+			return streamError(id, ErrCodeProtocol, "trailers without END_STREAM")
+		}
+		// handlers get no request trailers, they are dropped
+		return sc.endStream(st)
+	}
+	if id <= sc.lastStreamID {
+		return connError(ErrCodeStreamClosed, "HEADERS on closed stream %d", id)
+	}
+	sc.lastStreamID = id
+	if active >= maxConcurrentStreams {
+		return streamError(id, ErrCodeRefusedStream, "too many concurrent streams")
+	}
+	req, err := newRequest(fields)
+	if err != nil {
+		return streamError(id, ErrCodeProtocol, "%v", err)
+	}
+
+	st = sc.newStream(id, req)
+	if sc.headerEnd {
+		return sc.endStream(st)
+	}
+	return nil
+}
+
+func (sc *serverConn) processData(f *Frame) error {
+	if f.StreamID == 0 {
+		return connError(ErrCodeProtocol, "DATA on stream 0")
+	}
+	// bodies are buffered whole, so window goes back to the client as
+	// soon as data arrives and maxRequestBodySize and maxBufferedBodySize
+	// are what bound memory
+	if f.Length > 0 {
+		if err := sc.writeWindowUpdate(0, f.Length); err != nil {
+			return err
+		}
+	}
+
+	sc.mu.Lock()
+	st, exists := sc.streams[f.StreamID]
+	sc.mu.Unlock()
+	if !exists {
+		if f.StreamID > sc.lastStreamID {
+			return connError(ErrCodeProtocol, "DATA on idle stream %d", f.StreamID)
+		}
+		return streamError(f.StreamID, ErrCodeStreamClosed, "DATA on closed stream")
+	}
+	if st.halfClosed() {
+		return streamError(f.StreamID, ErrCodeStreamClosed, "DATA after end of stream")
+	}
+
+	if !st.tooLarge {
+		sc.mu.Lock()
+		refused := sc.buffered+int64(len(f.Payload)) > maxBufferedBodySize
+		if !refused {
+			sc.buffered += int64(len(f.Payload))
+			st.buffered += int64(len(f.Payload))
+		}
+		sc.mu.Unlock()
+		if refused {
+			return streamError(f.StreamID, ErrCodeRefusedStream, "connection buffers too much request body")
+		}
+		st.body.Write(f.Payload)
+		if st.body.Len() > maxRequestBodySize {
+			st.tooLarge = true
+			st.body = bytes.Buffer{}
+			sc.mu.Lock()
+			sc.releaseBody(st)
+			sc.mu.Unlock()
+			sc.startHandler(st)
+		}
+	}
+	if f.Flags.Has(FlagEndStream) {
+		return sc.endStream(st)
+	}
+	if f.Length > 0 {
+		return sc.writeWindowUpdate(f.StreamID, f.Length)
+	}
+	return nil
+}
+
+// endStream runs the handler once the client has sent the whole request.
+func (sc *serverConn) endStream(st *stream) error {
+	sc.mu.Lock()
+	st.remoteDone = true
+	// the body is the handler's from here on, or thrown away
+	sc.releaseBody(st)
+	sc.mu.Unlock()
+	if st.tooLarge {
+		// already answered with a 413
+		return nil
+	}
+	if contentLength, ok := st.req.Headers.Get("Content-Length"); ok {
+		if n, err := strconv.Atoi(contentLength); err != nil || n != st.body.Len() {
+			return streamError(st.id, ErrCodeProtocol, "body of %d bytes, content-length %s", st.body.Len(), contentLength)
+		}
+	}
+	st.req.Body = st.body.Bytes()
+	sc.startHandler(st)
+	return nil
+}
+
+func (sc *serverConn) newStream(id uint32, req *request.Request) *stream {
+	st := &stream{id: id, sc: sc, req: req}
+	st.ctx, st.cancel = context.WithCancel(sc.ctx)
+	sc.mu.Lock()
+	st.sendWindow = sc.peerInitialWindow
+	sc.streams[id] = st
+	sc.mu.Unlock()
+	return st
+}
+
+func (sc *serverConn) startHandler(st *stream) {
+	sc.handlers.Add(1)
+	sc.mu.Lock()
+	sc.running++
+	sc.mu.Unlock()
+	go func() {
+		defer sc.handlers.Done()
+		defer st.cancel()
+		w := response.NewFramedWriter(st)
+		if st.tooLarge {
+			response.WriteError(w, response.ContentTooLarge, "")
+		} else {
+			sc.handler(w, st.req.WithContext(st.ctx))
+		}
+		if err := w.Finish(); err != nil && !errors.Is(err, errStreamClosed) {
+			log.Println("Couldn't finish response:", err)
+		}
+
+		sc.mu.Lock()
+		sc.running--
+		delete(sc.streams, st.id)
+		// a response that went out before the whole request arrived
+		// tells the client to stop sending the rest
+		stopClient := !st.remoteDone && !st.reset
+		sc.mu.Unlock()
+		if stopClient {
+			sc.writeRSTStream(st.id, ErrCodeNo)
+		}
+	}()
+}
+
+func (sc *serverConn) resetStream(id uint32, code ErrCode) {
+	sc.mu.Lock()
+	if st, ok := sc.streams[id]; ok {
+		st.reset = true
+		st.cancel()
+		sc.releaseBody(st)
+		delete(sc.streams, id)
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+	sc.writeRSTStream(id, code)
+}
+
+// releaseBody takes st's body off the connection's buffered count. Callers
+// hold sc.mu.
+func (sc *serverConn) releaseBody(st *stream) {
+	sc.buffered -= st.buffered
+	st.buffered = 0
+}
+
+func (sc *serverConn) goAway(code ErrCode) {
+	payload := appendUint32(nil, sc.lastStreamID)
+	payload = appendUint32(payload, uint32(code))
+	sc.writeFrame(FrameGoAway, 0, 0, payload)
+}
+
+func (sc *serverConn) writeRSTStream(id uint32, code ErrCode) error {
+	return sc.writeFrame(FrameRSTStream, 0, id, appendUint32(nil, uint32(code)))
+}
+
+func (sc *serverConn) writeWindowUpdate(id uint32, increment uint32) error {
+	return sc.writeFrame(FrameWindowUpdate, 0, id, appendUint32(nil, increment))
+}
+
+func (sc *serverConn) writeFrame(t FrameType, flags Flags, id uint32, payload []byte) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	return WriteFrame(sc.conn, t, flags, id, payload)
+}
+
+// writeHeaderBlock encodes fields and sends them as HEADERS followed by as
+// many CONTINUATION frames as the peer's frame size calls for.
+func (sc *serverConn) writeHeaderBlock(id uint32, fields []hpack.HeaderField, endStream bool) error {
+	sc.mu.Lock()
+	maxFrame := int(sc.peerMaxFrameSize)
+	sc.mu.Unlock()
+
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	sc.hbuf.Reset()
+	for _, f := range fields {
+		if err := sc.henc.WriteField(f); err != nil {
+			return err
+		}
+	}
+	block := sc.hbuf.Bytes()
+	t := FrameHeaders
+	var flags Flags
+	if endStream {
+		flags = FlagEndStream
+	}
+	for {
+		n := min(len(block), maxFrame)
+		if n == len(block) {
+			flags |= FlagEndHeaders
+		}
+		if err := WriteFrame(sc.conn, t, flags, id, block[:n]); err != nil {
+			return err
+		}
+		block = block[n:]
+		if len(block) == 0 {
+			return nil
+		}
+		t, flags = FrameContinuation, 0
+	}
+}
+
+// connectionHeaders are specific to HTTP/1 connections and not allowed in
+// HTTP/2 messages.
+var connectionHeaders = []string{"connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade"}
+
+func isConnectionHeader(name string) bool {
+	for _, h := range connectionHeaders {
+		if name == h {
+			return true
+		}
+	}
+	return false
+}
+
+// newRequest builds a request from a decoded header block, checking the
+// rules RFC 9113 section 8.2 and 8.3 put on it.
+func newRequest(fields []hpack.HeaderField) (*request.Request, error) {
+	h := headers.NewHeaders()
+	pseudo := map[string]string{}
+	var cookies []string
+	regularSeen := false
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			if regularSeen {
+				return nil, fmt.Errorf("pseudo-header %s after regular headers", f.Name)
+			}
+			switch f.Name {
+			case ":method", ":scheme", ":authority", ":path":
+			default:
+				return nil, fmt.Errorf("unknown pseudo-header %s", f.Name)
+			}
+			if _, dup := pseudo[f.Name]; dup {
+				return nil, fmt.Errorf("duplicate %s", f.Name)
+			}
+			pseudo[f.Name] = f.Value
+			continue
+		}
+		regularSeen = true
+		if f.Name != strings.ToLower(f.Name) {
+			return nil, fmt.Errorf("uppercase header name %s", f.Name)
+		}
+		if isConnectionHeader(f.Name) {
+			return nil, fmt.Errorf("connection-specific header %s", f.Name)
+		}
+		if f.Name == "te" && f.Value != "trailers" {
+			return nil, fmt.Errorf("te: %s", f.Value)
+		}
+		if f.Name == "cookie" {
+			cookies = append(cookies, f.Value)
+			continue
+		}
+		if existing, ok := h[f.Name]; ok {
+			h[f.Name] = existing + ", " + f.Value
+		} else {
+			h[f.Name] = f.Value
+		}
+	}
+	if len(cookies) > 0 {
+		h["cookie"] = strings.Join(cookies, "; ")
+	}
+
+	method := pseudo[":method"]
+	if method == "" {
+		return nil, errors.New("missing :method")
+	}
+	if method != "CONNECT" && (pseudo[":scheme"] == "" || pseudo[":path"] == "") {
+		return nil, errors.New("missing :scheme or :path")
+	}
+	if authority := pseudo[":authority"]; authority != "" {
+		h["host"] = authority
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			HttpVersion:   "2",
+			RequestTarget: pseudo[":path"],
+			Method:        method,
+		},
+		Headers:    h,
+		Body:       []byte{},
+		ReceivedAt: time.Now(),
+	}, nil
+}
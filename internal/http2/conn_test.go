@@ -0,0 +1,472 @@
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2/hpack"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	enc  *hpack.Encoder
+	ebuf bytes.Buffer
+	dec  *hpack.Decoder
+}
+
+type testResponse struct {
+	fields   map[string]string
+	body     []byte
+	trailers map[string]string
+}
+
+// listen starts serve on a loopback listener for a single connection.
+func listen(t *testing.T, serve func(c net.Conn)) net.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		serve(c)
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func newTestClient(t *testing.T, handler Handler, settings ...Setting) *testClient {
+	conn := listen(t, func(c net.Conn) { ServeConn(c, c, handler) })
+	tc := wrapClient(t, conn)
+	_, err := io.WriteString(conn, Preface)
+	require.NoError(t, err)
+	tc.write(FrameSettings, 0, 0, encodeSettings(settings))
+	return tc
+}
+
+func wrapClient(t *testing.T, conn net.Conn) *testClient {
+	tc := &testClient{t: t, conn: conn, dec: hpack.NewDecoder(defaultTableSize, nil)}
+	tc.enc = hpack.NewEncoder(&tc.ebuf)
+	return tc
+}
+
+func (tc *testClient) write(t FrameType, flags Flags, id uint32, payload []byte) {
+	require.NoError(tc.t, WriteFrame(tc.conn, t, flags, id, payload))
+}
+
+func (tc *testClient) writeHeaders(id uint32, endStream bool, fields ...string) {
+	tc.ebuf.Reset()
+	for i := 0; i < len(fields); i += 2 {
+		require.NoError(tc.t, tc.enc.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]}))
+	}
+	flags := FlagEndHeaders
+	if endStream {
+		flags |= FlagEndStream
+	}
+	tc.write(FrameHeaders, flags, id, tc.ebuf.Bytes())
+}
+
+func (tc *testClient) get(id uint32, path string) {
+	tc.writeHeaders(id, true, ":method", "GET", ":scheme", "http", ":authority", "localhost", ":path", path)
+}
+
+func (tc *testClient) read() *Frame {
+	tc.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	f, err := ReadFrame(tc.conn, maxFrameSizeLimit)
+	require.NoError(tc.t, err)
+	return f
+}
+
+// readUntil reads frames, acknowledging SETTINGS, until until returns true.
+func (tc *testClient) readUntil(until func(f *Frame) bool) {
+	for {
+		f := tc.read()
+		if f.Type == FrameSettings && !f.Flags.Has(FlagAck) {
+			tc.write(FrameSettings, FlagAck, 0, nil)
+		}
+		if until(f) {
+			return
+		}
+	}
+}
+
+// responses collects the responses on streams ids.
+func (tc *testClient) responses(ids ...uint32) map[uint32]*testResponse {
+	res := map[uint32]*testResponse{}
+	for _, id := range ids {
+		res[id] = &testResponse{}
+	}
+	open := len(ids)
+	tc.readUntil(func(f *Frame) bool {
+		r, ok := res[f.StreamID]
+		if !ok {
+			return false
+		}
+		switch f.Type {
+		case FrameHeaders:
+			fields, err := tc.dec.DecodeFull(f.Payload)
+			require.NoError(tc.t, err)
+			m := map[string]string{}
+			for _, hf := range fields {
+				m[hf.Name] = hf.Value
+			}
+			if r.fields == nil {
+				r.fields = m
+			} else {
+				r.trailers = m
+			}
+		case FrameData:
+			r.body = append(r.body, f.Payload...)
+		case FrameRSTStream:
+			tc.t.Fatalf("stream %d reset", f.StreamID)
+		}
+		if f.Flags.Has(FlagEndStream) && (f.Type == FrameHeaders || f.Type == FrameData) {
+			open--
+		}
+		return open == 0
+	})
+	return res
+}
+
+func textHandler(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + req.Headers["host"] + " " + string(req.Body))
+	w.WriteStatusLine(response.OK)
+	h := response.GetDefaultHeaders(len(body))
+	h.Override("Content-Type", "text/plain")
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+func TestServeConn(t *testing.T) {
+	tc := newTestClient(t, textHandler)
+
+	// Test: Server settings come first
+	f := tc.read()
+	assert.Equal(t, FrameSettings, f.Type)
+	assert.False(t, f.Flags.Has(FlagAck))
+
+	// Test: Simple GET
+	tc.get(1, "/hello")
+	res := tc.responses(1)[1]
+	assert.Equal(t, "200", res.fields[":status"])
+	assert.Equal(t, "text/plain", res.fields["content-type"])
+	_, hasConnection := res.fields["connection"]
+	assert.False(t, hasConnection)
+	assert.Equal(t, "GET /hello localhost ", string(res.body))
+
+	// Test: Ping is echoed
+	tc.write(FramePing, 0, 0, []byte("12345678"))
+	tc.readUntil(func(f *Frame) bool {
+		if f.Type != FramePing {
+			return false
+		}
+		assert.True(t, f.Flags.Has(FlagAck))
+		assert.Equal(t, "12345678", string(f.Payload))
+		return true
+	})
+}
+
+func TestRequestBody(t *testing.T) {
+	tc := newTestClient(t, textHandler)
+
+	tc.writeHeaders(1, false, ":method", "POST", ":scheme", "http", ":authority", "localhost", ":path", "/echo", "content-length", "11")
+	tc.write(FrameData, 0, 1, []byte("hello "))
+	// padded, the padding counts against flow control but isn't data
+	tc.write(FrameData, FlagEndStream|FlagPadded, 1, append([]byte{3, 'w', 'o', 'r', 'l', 'd'}, 0, 0, 0))
+	res := tc.responses(1)[1]
+	assert.Equal(t, "POST /echo localhost hello world", string(res.body))
+
+	// Test: Content-Length has to match
+	tc.writeHeaders(3, false, ":method", "POST", ":scheme", "http", ":authority", "localhost", ":path", "/echo", "content-length", "3")
+	tc.write(FrameData, FlagEndStream, 3, []byte("hello"))
+	tc.readUntil(func(f *Frame) bool {
+		return f.Type == FrameRSTStream && f.StreamID == 3
+	})
+}
+
+func TestBufferedBodyLimit(t *testing.T) {
+	tc := newTestClient(t, func(w *response.Writer, req *request.Request) {
+		body := []byte(fmt.Sprint(len(req.Body)))
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+	// the server's window updates and resets have to be read while the
+	// bodies go out, or both sides end up blocked on full buffers
+	frames := make(chan *Frame, 1<<14)
+	go func() {
+		defer close(frames)
+		for {
+			f, err := ReadFrame(tc.conn, maxFrameSizeLimit)
+			if err != nil {
+				return
+			}
+			if f.Type != FrameWindowUpdate {
+				frames <- f
+			}
+		}
+	}()
+	chunk := make([]byte, defaultMaxFrameSize)
+	// just under the per-stream limit, three of them just under the
+	// connection's
+	size := maxRequestBodySize - len(chunk)
+	sendBody := func(id uint32, endStream bool) {
+		tc.writeHeaders(id, false, ":method", "POST", ":scheme", "http", ":authority", "localhost", ":path", "/")
+		for sent := 0; sent < size; sent += len(chunk) {
+			var flags Flags
+			if endStream && sent+len(chunk) == size {
+				flags = FlagEndStream
+			}
+			tc.write(FrameData, flags, id, chunk)
+		}
+	}
+	bodySize := fmt.Sprint(size)
+
+	// Test: A stream that would go past the connection's limit is refused,
+	// until a handler takes its body
+	sendBody(1, false)
+	sendBody(3, false)
+	sendBody(5, false)
+	sendBody(7, true)
+	tc.write(FrameData, FlagEndStream, 1, nil)
+	sendBody(9, true)
+
+	refused := false
+	bodies := map[uint32]string{}
+	for f := range frames {
+		if f.Type == FrameRSTStream && f.StreamID == 7 {
+			refused = refused || bytes.Equal(appendUint32(nil, uint32(ErrCodeRefusedStream)), f.Payload)
+		}
+		if f.Type == FrameData {
+			bodies[f.StreamID] += string(f.Payload)
+		}
+		if len(bodies) == 2 {
+			break
+		}
+	}
+	assert.True(t, refused)
+	assert.Equal(t, map[uint32]string{1: bodySize, 9: bodySize}, bodies)
+}
+
+func TestMultiplexing(t *testing.T) {
+	fastDone := make(chan struct{})
+	tc := newTestClient(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			<-fastDone
+		} else {
+			defer close(fastDone)
+		}
+		textHandler(w, req)
+	})
+
+	// Test: The slow stream doesn't hold up the fast one
+	tc.get(1, "/slow")
+	tc.get(3, "/fast")
+	res := tc.responses(1, 3)
+	assert.Equal(t, "GET /slow localhost ", string(res[1].body))
+	assert.Equal(t, "GET /fast localhost ", string(res[3].body))
+}
+
+func TestFlowControl(t *testing.T) {
+	body := []byte(strings.Repeat("x", 25))
+	tc := newTestClient(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, Setting{SettingInitialWindowSize, 10})
+
+	tc.get(1, "/")
+	received := 0
+	tc.readUntil(func(f *Frame) bool {
+		if f.Type == FrameData {
+			received += len(f.Payload)
+		}
+		return received == 10
+	})
+
+	// Test: Nothing more until the window opens
+	tc.conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := ReadFrame(tc.conn, maxFrameSizeLimit)
+	assert.Error(t, err)
+
+	tc.write(FrameWindowUpdate, 0, 1, appendUint32(nil, 15))
+	tc.readUntil(func(f *Frame) bool {
+		if f.Type == FrameData {
+			received += len(f.Payload)
+		}
+		return f.Flags.Has(FlagEndStream)
+	})
+	assert.Equal(t, 25, received)
+}
+
+func TestStreamErrors(t *testing.T) {
+	tc := newTestClient(t, textHandler)
+
+	// Test: Uppercase header names reset the stream
+	tc.writeHeaders(1, true, ":method", "GET", ":scheme", "http", ":path", "/", "X-Upper", "1")
+	tc.readUntil(func(f *Frame) bool {
+		if f.Type != FrameRSTStream {
+			return false
+		}
+		assert.Equal(t, uint32(1), f.StreamID)
+		assert.Equal(t, appendUint32(nil, uint32(ErrCodeProtocol)), f.Payload)
+		return true
+	})
+
+	// Test: The connection survives it
+	tc.get(3, "/after")
+	assert.Equal(t, "GET /after localhost ", string(tc.responses(3)[3].body))
+
+	// Test: Reused stream ids end the connection
+	tc.get(3, "/again")
+	tc.readUntil(func(f *Frame) bool { return f.Type == FrameGoAway })
+}
+
+func TestStreamContext(t *testing.T) {
+	cancelled := make(chan error, 1)
+	tc := newTestClient(t, func(w *response.Writer, req *request.Request) {
+		select {
+		case <-req.Context().Done():
+			cancelled <- req.Context().Err()
+		case <-time.After(2 * time.Second):
+			cancelled <- nil
+		}
+	})
+
+	// Test: RST_STREAM cancels the handler's context
+	tc.get(1, "/slow")
+	tc.write(FrameRSTStream, 0, 1, appendUint32(nil, uint32(ErrCodeCancel)))
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+
+	// Test: So does the connection going away
+	tc.get(3, "/slow")
+	tc.conn.Close()
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+}
+
+func TestRapidReset(t *testing.T) {
+	release := make(chan struct{})
+	var running atomic.Int32
+	tc := newTestClient(t, func(w *response.Writer, req *request.Request) {
+		running.Add(1)
+		defer running.Add(-1)
+		// ignores the reset, like a handler stuck in a slow call would
+		<-release
+	})
+	defer close(release)
+
+	// Test: Resetting streams doesn't free their place while their
+	// handlers still run
+	refused := 0
+	for id := uint32(1); id < 2*3*maxConcurrentStreams; id += 2 {
+		tc.get(id, "/")
+		tc.write(FrameRSTStream, 0, id, appendUint32(nil, uint32(ErrCodeCancel)))
+	}
+	tc.write(FramePing, 0, 0, []byte("12345678"))
+	tc.readUntil(func(f *Frame) bool {
+		if f.Type == FrameRSTStream && bytes.Equal(appendUint32(nil, uint32(ErrCodeRefusedStream)), f.Payload) {
+			refused++
+		}
+		return f.Type == FramePing
+	})
+	assert.Equal(t, 2*maxConcurrentStreams, refused)
+	assert.Eventually(t, func() bool { return running.Load() == maxConcurrentStreams }, time.Second, 5*time.Millisecond)
+	assert.Never(t, func() bool { return running.Load() > maxConcurrentStreams }, 50*time.Millisecond, 5*time.Millisecond)
+}
+
+func TestFirstFrameMustBeSettings(t *testing.T) {
+	conn := listen(t, func(c net.Conn) { ServeConn(c, c, textHandler) })
+	tc := wrapClient(t, conn)
+	io.WriteString(conn, Preface)
+	tc.write(FramePing, 0, 0, []byte("12345678"))
+	tc.readUntil(func(f *Frame) bool {
+		if f.Type != FrameGoAway {
+			return false
+		}
+		assert.Equal(t, uint32(ErrCodeProtocol), uint32(f.Payload[7]))
+		return true
+	})
+}
+
+func TestServeUpgrade(t *testing.T) {
+	conn := listen(t, func(c net.Conn) {
+		r := bufio.NewReader(c)
+		req, err := request.RequestFromReader(r)
+		if err != nil || !IsUpgrade(req) {
+			return
+		}
+		ServeUpgrade(c, r, req, textHandler)
+	})
+	settings := base64.RawURLEncoding.EncodeToString(encodeSettings([]Setting{{SettingInitialWindowSize, 1 << 20}}))
+	_, err := io.WriteString(conn, "GET /upgraded HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: "+settings+"\r\n\r\n")
+	require.NoError(t, err)
+
+	status := make([]byte, len("HTTP/1.1 101 Switching Protocols\r\n"))
+	_, err = io.ReadFull(conn, status)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", string(status))
+	rest := make([]byte, len("Connection: Upgrade\r\nUpgrade: h2c\r\n\r\n"))
+	_, err = io.ReadFull(conn, rest)
+	require.NoError(t, err)
+
+	tc := wrapClient(t, conn)
+	io.WriteString(conn, Preface)
+	tc.write(FrameSettings, 0, 0, nil)
+	res := tc.responses(1)[1]
+	assert.Equal(t, "200", res.fields[":status"])
+	assert.Equal(t, "GET /upgraded localhost ", string(res.body))
+}
+
+func TestIsUpgrade(t *testing.T) {
+	req := &request.Request{Headers: map[string]string{
+		"upgrade":        "h2c",
+		"connection":     "Upgrade, HTTP2-Settings",
+		"http2-settings": "",
+	}}
+	assert.True(t, IsUpgrade(req))
+	req.Headers["upgrade"] = "websocket"
+	assert.False(t, IsUpgrade(req))
+}
+
+func TestHasPreface(t *testing.T) {
+	assert.True(t, HasPreface(bufio.NewReader(strings.NewReader(Preface+"rest"))))
+	// shorter than the preface, must not wait for more
+	assert.False(t, HasPreface(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))))
+	assert.False(t, HasPreface(bufio.NewReader(strings.NewReader("PRI * HTTP/1.1\r\n"))))
+}
+
+func TestReadFrame(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteFrame(&buf, FrameHeaders, FlagPadded|FlagPriority|FlagEndHeaders, 5,
+		append([]byte{2, 0, 0, 0, 3, 16, 'h', 'i'}, 0, 0)))
+	f, err := ReadFrame(&buf, defaultMaxFrameSize)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(10), f.Length)
+	assert.Equal(t, uint32(5), f.StreamID)
+	assert.Equal(t, "hi", string(f.Payload))
+
+	// Test: Oversized frames
+	require.NoError(t, WriteFrame(&buf, FrameData, 0, 1, make([]byte, defaultMaxFrameSize+1)))
+	_, err = ReadFrame(&buf, defaultMaxFrameSize)
+	var ce ConnectionError
+	require.ErrorAs(t, err, &ce)
+	assert.Equal(t, ErrCodeFrameSize, ce.Code)
+}
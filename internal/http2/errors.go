@@ -0,0 +1,51 @@
+package http2
+
+import "fmt"
+
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+// ConnectionError ends the whole connection with a GOAWAY.
+type ConnectionError struct {
+	Code   ErrCode
+	Reason string
+}
+
+func (e ConnectionError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.Code, e.Reason)
+}
+
+func connError(code ErrCode, format string, args ...any) ConnectionError {
+	return ConnectionError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// StreamError resets a single stream and leaves the others alone.
+type StreamError struct {
+	StreamID uint32
+	Code     ErrCode
+	Reason   string
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error %d: %s", e.StreamID, e.Code, e.Reason)
+}
+
+func streamError(id uint32, code ErrCode, format string, args ...any) StreamError {
+	return StreamError{StreamID: id, Code: code, Reason: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,135 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Preface is what every HTTP/2 client sends before its first frame.
+const Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const frameHeaderLen = 9
+
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+type Flags uint8
+
+const (
+	FlagEndStream  Flags = 0x1
+	FlagAck        Flags = 0x1
+	FlagEndHeaders Flags = 0x4
+	FlagPadded     Flags = 0x8
+	FlagPriority   Flags = 0x20
+)
+
+func (f Flags) Has(v Flags) bool {
+	return f&v == v
+}
+
+// Frame is a frame as read off the wire, its payload not interpreted yet
+// beyond stripping padding and priority fields.
+type Frame struct {
+	// Length is the payload length on the wire, padding included, which is
+	// what flow control counts.
+	Length   uint32
+	Type     FrameType
+	Flags    Flags
+	StreamID uint32
+	Payload  []byte
+}
+
+// ReadFrame reads the next frame, refusing payloads over maxSize.
+func ReadFrame(r io.Reader, maxSize uint32) (*Frame, error) {
+	var head [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	length := uint32(head[0])<<16 | uint32(head[1])<<8 | uint32(head[2])
+	f := &Frame{
+		Length:   length,
+		Type:     FrameType(head[3]),
+		Flags:    Flags(head[4]),
+		StreamID: binary.BigEndian.Uint32(head[5:]) & (1<<31 - 1),
+	}
+	if length > maxSize {
+		return nil, connError(ErrCodeFrameSize, "frame of %d bytes exceeds %d", length, maxSize)
+	}
+	f.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, err
+	}
+	if err := f.stripPadding(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// stripPadding removes the pad length, padding and priority fields of DATA
+// and HEADERS frames so Payload only holds the data or header block.
+func (f *Frame) stripPadding() error {
+	if f.Type != FrameData && f.Type != FrameHeaders {
+		return nil
+	}
+	p := f.Payload
+	padLen := 0
+	if f.Flags.Has(FlagPadded) {
+		if len(p) < 1 {
+			return connError(ErrCodeFrameSize, "padded frame without pad length")
+		}
+		padLen = int(p[0])
+		p = p[1:]
+	}
+	if f.Type == FrameHeaders && f.Flags.Has(FlagPriority) {
+		if len(p) < 5 {
+			return connError(ErrCodeFrameSize, "HEADERS too short for priority")
+		}
+		p = p[5:]
+	}
+	if padLen > len(p) {
+		return connError(ErrCodeProtocol, "padding exceeds payload")
+	}
+	f.Payload = p[:len(p)-padLen]
+	return nil
+}
+
+// WriteFrame writes one frame. The caller keeps len(payload) within the
+// peer's maximum frame size.
+func WriteFrame(w io.Writer, t FrameType, flags Flags, streamID uint32, payload []byte) error {
+	buf := make([]byte, frameHeaderLen+len(payload))
+	buf[0] = byte(len(payload) >> 16)
+	buf[1] = byte(len(payload) >> 8)
+	buf[2] = byte(len(payload))
+	buf[3] = byte(t)
+	buf[4] = byte(flags)
+	binary.BigEndian.PutUint32(buf[5:], streamID&(1<<31-1))
+	copy(buf[frameHeaderLen:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+func (t FrameType) String() string {
+	names := []string{"DATA", "HEADERS", "PRIORITY", "RST_STREAM", "SETTINGS",
+		"PUSH_PROMISE", "PING", "GOAWAY", "WINDOW_UPDATE", "CONTINUATION"}
+	if int(t) < len(names) {
+		return names[t]
+	}
+	return fmt.Sprintf("UNKNOWN_%d", uint8(t))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return binary.BigEndian.AppendUint32(b, v)
+}
@@ -0,0 +1,67 @@
+package http2
+
+import "encoding/binary"
+
+type SettingID uint16
+
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+const (
+	defaultWindowSize   = 65535
+	maxWindowSize       = 1<<31 - 1
+	defaultMaxFrameSize = 16384
+	maxFrameSizeLimit   = 1<<24 - 1
+	defaultTableSize    = 4096
+)
+
+type Setting struct {
+	ID    SettingID
+	Value uint32
+}
+
+// parseSettings decodes a SETTINGS payload, checking values the RFC puts
+// bounds on. Unknown settings are kept and ignored by the caller.
+func parseSettings(p []byte) ([]Setting, error) {
+	if len(p)%6 != 0 {
+		return nil, connError(ErrCodeFrameSize, "SETTINGS length %d not a multiple of 6", len(p))
+	}
+	settings := make([]Setting, 0, len(p)/6)
+	for i := 0; i < len(p); i += 6 {
+		s := Setting{
+			ID:    SettingID(binary.BigEndian.Uint16(p[i:])),
+			Value: binary.BigEndian.Uint32(p[i+2:]),
+		}
+		switch s.ID {
+		case SettingEnablePush:
+			if s.Value > 1 {
+				return nil, connError(ErrCodeProtocol, "ENABLE_PUSH %d", s.Value)
+			}
+		case SettingInitialWindowSize:
+			if s.Value > maxWindowSize {
+				return nil, connError(ErrCodeFlowControl, "INITIAL_WINDOW_SIZE %d", s.Value)
+			}
+		case SettingMaxFrameSize:
+			if s.Value < defaultMaxFrameSize || s.Value > maxFrameSizeLimit {
+				return nil, connError(ErrCodeProtocol, "MAX_FRAME_SIZE %d", s.Value)
+			}
+		}
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+func encodeSettings(settings []Setting) []byte {
+	p := make([]byte, 0, 6*len(settings))
+	for _, s := range settings {
+		p = binary.BigEndian.AppendUint16(p, uint16(s.ID))
+		p = binary.BigEndian.AppendUint32(p, s.Value)
+	}
+	return p
+}
@@ -0,0 +1,163 @@
+package http2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"golang.org/x/net/http2/hpack"
+)
+
+var errStreamClosed = errors.New("http2: stream closed")
+
+// stream is one request and its response. It is the response.Framer the
+// handler's Writer writes through.
+type stream struct {
+	id  uint32
+	sc  *serverConn
+	req *request.Request
+	// ctx is the request's, cancelled when the stream is reset.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// read loop only
+	body     bytes.Buffer
+	tooLarge bool
+
+	// guarded by sc.mu
+	sendWindow int64
+	remoteDone bool
+	reset      bool
+	// buffered counts the body toward sc.buffered until it is handed
+	// over or dropped
+	buffered int64
+
+	// handler goroutine only
+	wroteHead bool
+	ended     bool
+}
+
+func (st *stream) halfClosed() bool {
+	st.sc.mu.Lock()
+	defer st.sc.mu.Unlock()
+	return st.remoteDone
+}
+
+func (st *stream) WriteHead(statusCode response.StatusCode, h headers.Headers) error {
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(int(statusCode))}}
+	for key, value := range h {
+		name := strings.ToLower(key)
+		if isConnectionHeader(name) {
+			continue
+		}
+		fields = append(fields, hpack.HeaderField{Name: name, Value: value})
+	}
+	if err := st.checkOpen(); err != nil {
+		return err
+	}
+	st.wroteHead = true
+	return st.sc.writeHeaderBlock(st.id, fields, false)
+}
+
+func (st *stream) WriteBody(p []byte) (int, error) {
+	return st.writeData(p, false)
+}
+
+func (st *stream) WriteChunk(p []byte) (int, error) {
+	return st.writeData(p, false)
+}
+
+func (st *stream) EndChunks() error {
+	return nil
+}
+
+func (st *stream) WriteTrailers(t headers.Headers) error {
+	fields := make([]hpack.HeaderField, 0, len(t))
+	for key, value := range t {
+		fields = append(fields, hpack.HeaderField{Name: strings.ToLower(key), Value: value})
+	}
+	if err := st.checkOpen(); err != nil {
+		return err
+	}
+	st.ended = true
+	return st.sc.writeHeaderBlock(st.id, fields, true)
+}
+
+// Flush has nothing to do, frames go out as they're written.
+func (st *stream) Flush() error {
+	return nil
+}
+
+func (st *stream) Close() error {
+	if !st.wroteHead {
+		// the handler wrote nothing at all, which HTTP/2 has no way to
+		// express but a reset
+		st.sc.resetStream(st.id, ErrCodeInternal)
+		return nil
+	}
+	if st.ended {
+		return nil
+	}
+	_, err := st.writeData(nil, true)
+	return err
+}
+
+// Abort resets the stream, telling the client the response is incomplete.
+func (st *stream) Abort() error {
+	st.sc.resetStream(st.id, ErrCodeInternal)
+	return nil
+}
+
+func (st *stream) checkOpen() error {
+	st.sc.mu.Lock()
+	defer st.sc.mu.Unlock()
+	if st.reset || st.sc.closed {
+		return errStreamClosed
+	}
+	return nil
+}
+
+// writeData sends p as DATA frames, waiting for the client to open up its
+// flow control windows as needed.
+func (st *stream) writeData(p []byte, endStream bool) (int, error) {
+	if len(p) == 0 && !endStream {
+		return 0, nil
+	}
+	sc := st.sc
+	written := 0
+	for {
+		sc.mu.Lock()
+		for len(p) > 0 && !st.reset && !sc.closed && (st.sendWindow <= 0 || sc.sendWindow <= 0) {
+			sc.cond.Wait()
+		}
+		if st.reset || sc.closed {
+			sc.mu.Unlock()
+			return written, errStreamClosed
+		}
+		n := int64(len(p))
+		n = min(n, st.sendWindow, sc.sendWindow, int64(sc.peerMaxFrameSize))
+		st.sendWindow -= n
+		sc.sendWindow -= n
+		sc.mu.Unlock()
+
+		var flags Flags
+		last := int(n) == len(p)
+		if last && endStream {
+			flags = FlagEndStream
+		}
+		if err := sc.writeFrame(FrameData, flags, st.id, p[:n]); err != nil {
+			return written, err
+		}
+		written += int(n)
+		p = p[n:]
+		if last {
+			st.ended = endStream
+			return written, nil
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package http2
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+)
+
+// ErrBadUpgrade means an h2c upgrade request can't be honoured and should be
+// answered over HTTP/1.1 instead. Nothing has been written when it's
+// returned.
+var ErrBadUpgrade = errors.New("http2: malformed h2c upgrade")
+
+// IsUpgrade tells whether req asks to switch the connection to h2c.
+func IsUpgrade(req *request.Request) bool {
+	upgrade, _ := req.Headers.Get("Upgrade")
+	connection, _ := req.Headers.Get("Connection")
+	_, hasSettings := req.Headers.Get("HTTP2-Settings")
+	return hasSettings && hasToken(upgrade, "h2c") && hasToken(connection, "upgrade")
+}
+
+func hasToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeUpgrade switches c to HTTP/2 after req asked for it with Upgrade: h2c,
+// answers req itself on stream 1 and then serves the connection like
+// ServeConn does.
+func ServeUpgrade(c net.Conn, r io.Reader, req *request.Request, handler Handler) error {
+	encoded, _ := req.Headers.Lookup("HTTP2-Settings")
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(encoded), "="))
+	if err != nil {
+		return ErrBadUpgrade
+	}
+	settings, err := parseSettings(payload)
+	if err != nil {
+		return ErrBadUpgrade
+	}
+
+	if _, err := io.WriteString(c, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"); err != nil {
+		return err
+	}
+	sc := newServerConn(c, r, handler)
+	if err := sc.writeSettings(); err != nil {
+		return err
+	}
+	// the 101 acknowledges these, no SETTINGS ACK goes out for them
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+
+	for _, name := range []string{"Connection", "Upgrade", "HTTP2-Settings"} {
+		req.Headers.Delete(name)
+	}
+	req.RequestLine.HttpVersion = "2"
+	sc.lastStreamID = 1
+	st := sc.newStream(1, req)
+	st.remoteDone = true
+	sc.startHandler(st)
+	return sc.serve()
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/server"
+)
+
+// Handler serves the registry's metrics, for mounting at /metrics.
+func Handler(r *Registry) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		var body bytes.Buffer
+		if err := r.WriteText(&body); err != nil {
+			response.WriteError(w, response.InternalServerError, "")
+			return
+		}
+		h := headers.NewHeaders()
+		h.Set("Cache-Control", "no-store")
+		response.ServeContent(w, req, response.Content{
+			Body:        bytes.NewReader(body.Bytes()),
+			Size:        int64(body.Len()),
+			ContentType: ContentType,
+			Headers:     h,
+		})
+	}
+}
+
+// Middleware counts requests by method, route and status and observes how
+// long they took. route maps a request to a label of few distinct values,
+// like the route it matched; raw targets would make a series per URL.
+// It registers its metrics, so call it once per registry.
+func Middleware(r *Registry, route func(req *request.Request) string) server.Middleware {
+	requests := r.NewCounter("http_requests_total", "Requests handled.", "method", "route", "status")
+	durations := r.NewHistogram("http_request_duration_seconds", "Time spent handling requests.", nil, "method", "route")
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+			method, name := methodLabel(req.RequestLine.Method), route(req)
+			requests.Inc(method, name, strconv.Itoa(int(w.StatusCode())))
+			durations.Observe(time.Since(start).Seconds(), method, name)
+		}
+	}
+}
+
+// methodLabel keeps the method label to the standard methods, anything a
+// client makes up is counted as OTHER.
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH":
+		return method
+	}
+	return "OTHER"
+}
+
+// RegisterServer exposes the connection level counters of s.
+func RegisterServer(r *Registry, s *server.Server) {
+	r.NewGaugeFunc("http_server_active_connections", "Connections currently open.", func() float64 {
+		return float64(s.Stats().ActiveConns)
+	})
+	r.NewCounterFunc("http_server_connections_total", "Connections accepted.", func() float64 {
+		return float64(s.Stats().AcceptedConns)
+	})
+	r.NewCounterFunc("http_server_received_bytes_total", "Bytes read from connections.", func() float64 {
+		return float64(s.Stats().BytesIn)
+	})
+	r.NewCounterFunc("http_server_sent_bytes_total", "Bytes written to connections.", func() float64 {
+		return float64(s.Stats().BytesOut)
+	})
+	r.NewCounterFunc("http_server_extra_requests_total", "Requests beyond the first on their connection, over keep-alive or as further HTTP/2 streams.", func() float64 {
+		return float64(s.Stats().ReusedRequests)
+	})
+	r.NewCounterMapFunc("http_server_parse_errors_total", "Requests that couldn't be parsed.", "type", func() map[string]uint64 {
+		return s.Stats().ParseErrors
+	})
+}
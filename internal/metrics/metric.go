@@ -0,0 +1,207 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// DefaultBuckets suit request durations in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// values keeps one float per combination of label values.
+type values struct {
+	*desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newValues(d *desc) *values {
+	return &values{desc: d, series: map[string]*series{}}
+}
+
+func (v *values) add(delta float64, labelValues []string) {
+	v.update(labelValues, func(s *series) { s.value += delta })
+}
+
+func (v *values) update(labelValues []string, f func(s *series)) {
+	key := seriesKey(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labels: v.pairs(labelValues)}
+		v.series[key] = s
+	}
+	f(s)
+}
+
+func (v *values) collect() []sample {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	samples := make([]sample, 0, len(v.series))
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		samples = append(samples, sample{labels: s.labels, value: s.value})
+	}
+	return samples
+}
+
+// Counter only goes up. Label values are passed in the order the label
+// names were given.
+type Counter struct {
+	v *values
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{v: newValues(&desc{name: name, help: help, typ: counterType, labels: labels})}
+	r.register(c.v)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.v.add(1, labelValues)
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metrics: counter %s can't go down", c.v.name))
+	}
+	c.v.add(delta, labelValues)
+}
+
+type Gauge struct {
+	v *values
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{v: newValues(&desc{name: name, help: help, typ: gaugeType, labels: labels})}
+	r.register(g.v)
+	return g
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.v.update(labelValues, func(s *series) { s.value = value })
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.v.add(delta, labelValues)
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	*desc
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []labelPair
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram uses buckets as upper bounds, DefaultBuckets when nil. The
+// +Inf bucket is implied.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{
+		desc:    &desc{name: name, help: help, typ: histogramType, labels: labels},
+		buckets: buckets,
+		series:  map[string]*histogramSeries{},
+	}
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := seriesKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: h.pairs(labelValues), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *Histogram) collect() []sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var samples []sample
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			samples = append(samples, sample{suffix: "_bucket", labels: withLe(s.labels, upper), value: float64(cumulative)})
+		}
+		samples = append(samples,
+			sample{suffix: "_bucket", labels: withLe(s.labels, math.Inf(1)), value: float64(s.count)},
+			sample{suffix: "_sum", labels: s.labels, value: s.sum},
+			sample{suffix: "_count", labels: s.labels, value: float64(s.count)},
+		)
+	}
+	return samples
+}
+
+func withLe(labels []labelPair, upper float64) []labelPair {
+	return append(append([]labelPair{}, labels...), labelPair{"le", formatValue(upper)})
+}
+
+// funcMetric reads its samples from elsewhere at scrape time.
+type funcMetric struct {
+	*desc
+	f func() []sample
+}
+
+func (m *funcMetric) collect() []sample {
+	return m.f()
+}
+
+// NewCounterFunc exposes a counter kept somewhere else, read by f on every
+// scrape.
+func (r *Registry) NewCounterFunc(name, help string, f func() float64) {
+	r.register(&funcMetric{
+		desc: &desc{name: name, help: help, typ: counterType},
+		f:    func() []sample { return []sample{{value: f()}} },
+	})
+}
+
+// NewGaugeFunc is NewCounterFunc for gauges.
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(&funcMetric{
+		desc: &desc{name: name, help: help, typ: gaugeType},
+		f:    func() []sample { return []sample{{value: f()}} },
+	})
+}
+
+// NewCounterMapFunc exposes counters kept in a map from the value of label
+// to the count, read by f on every scrape.
+func (r *Registry) NewCounterMapFunc(name, help, label string, f func() map[string]uint64) {
+	d := &desc{name: name, help: help, typ: counterType, labels: []string{label}}
+	r.register(&funcMetric{
+		desc: d,
+		f: func() []sample {
+			m := f()
+			samples := make([]sample, 0, len(m))
+			for _, key := range sortedKeys(m) {
+				samples = append(samples, sample{labels: d.pairs([]string{key}), value: float64(m[key])})
+			}
+			return samples
+		},
+	})
+}
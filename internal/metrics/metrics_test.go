@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/server"
+	"github.com/felixsolom/http-from-tcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func render(t *testing.T, r *Registry) string {
+	var out bytes.Buffer
+	require.NoError(t, r.WriteText(&out))
+	return out.String()
+}
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("jobs_total", "Jobs done.\nPer queue.", "queue")
+	g := r.NewGauge("temperature", "Current temperature.")
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{0.5, 0.1})
+	c.Inc("b")
+	c.Add(2, `a"\`)
+	g.Set(21.5)
+	g.Add(-1)
+	h.Observe(0.05)
+	h.Observe(0.3)
+	h.Observe(7)
+
+	// Test: Registration order, sorted series, escaping and cumulative buckets
+	assert.Equal(t, `# HELP jobs_total Jobs done.\nPer queue.
+# TYPE jobs_total counter
+jobs_total{queue="a\"\\"} 2
+jobs_total{queue="b"} 1
+# HELP temperature Current temperature.
+# TYPE temperature gauge
+temperature 20.5
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="0.5"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 7.35
+latency_seconds_count 3
+`, render(t, r))
+
+	// Test: Misuse panics
+	assert.Panics(t, func() { r.NewGauge("temperature", "again") })
+	assert.Panics(t, func() { r.NewGauge("bad-name", "") })
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { c.Add(-1, "a") })
+}
+
+func TestMiddleware(t *testing.T) {
+	r := NewRegistry()
+	handler := Middleware(r, func(req *request.Request) string { return "/things/" })(
+		func(w *response.Writer, req *request.Request) {
+			response.WriteError(w, response.NotFound, "")
+		})
+	servertest.Record(handler, servertest.NewRequest("GET", "/things/1", nil))
+	servertest.Record(handler, servertest.NewRequest("GET", "/things/2", nil))
+
+	// Test: Requests by method, route and status, plus durations
+	out := render(t, r)
+	assert.Contains(t, out, `http_requests_total{method="GET",route="/things/",status="404"} 2`+"\n")
+	assert.Contains(t, out, `http_request_duration_seconds_count{method="GET",route="/things/"} 2`+"\n")
+
+	// Test: Made up methods share a label
+	servertest.Record(handler, servertest.NewRequest("BREW", "/things/1", nil))
+	servertest.Record(handler, servertest.NewRequest("WHEN", "/things/1", nil))
+	out = render(t, r)
+	assert.Contains(t, out, `http_requests_total{method="OTHER",route="/things/",status="404"} 2`+"\n")
+	assert.NotContains(t, out, "BREW")
+
+	// Test: Served in the exposition format
+	res, err := servertest.Record(Handler(r), servertest.NewRequest("GET", "/metrics", nil))
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, res.StatusLine.StatusCode)
+	assert.Equal(t, ContentType, res.Headers["content-type"])
+	assert.Contains(t, string(res.Body), "# TYPE http_requests_total counter\n")
+}
+
+func TestRegisterServer(t *testing.T) {
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		response.WriteError(w, response.OK, "ok")
+	}, server.WithLocalhostOnly())
+	require.NoError(t, err)
+	defer s.Close()
+	r := NewRegistry()
+	RegisterServer(r, s)
+
+	send := func(raw string) {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, raw)
+		require.NoError(t, err)
+		_, err = io.ReadAll(conn)
+		require.NoError(t, err)
+	}
+	send("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	send("get / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	// Test: Connection counters and parse errors by type
+	out := render(t, r)
+	assert.Contains(t, out, "http_server_connections_total 2\n")
+	assert.Contains(t, out, `http_server_parse_errors_total{type="request_line"} 1`+"\n")
+	assert.Contains(t, out, "http_server_received_bytes_total 70\n")
+	assert.Contains(t, out, "# TYPE http_server_active_connections gauge\n")
+	assert.Contains(t, out, "http_server_extra_requests_total 0\n")
+}
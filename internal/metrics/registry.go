@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the Prometheus text exposition format, version 0.0.4.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var validName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// sample is one line of output. suffix is appended to the metric name,
+// like _bucket for histograms.
+type sample struct {
+	suffix string
+	labels []labelPair
+	value  float64
+}
+
+type labelPair struct {
+	name, value string
+}
+
+type metric interface {
+	describe() *desc
+	collect() []sample
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    metricType
+	labels []string
+}
+
+func (d *desc) describe() *desc {
+	return d
+}
+
+// pairs matches label names to values given in the same order.
+func (d *desc) pairs(values []string) []labelPair {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	pairs := make([]labelPair, len(values))
+	for i, v := range values {
+		pairs[i] = labelPair{d.labels[i], v}
+	}
+	return pairs
+}
+
+// Registry holds metrics and renders them. Metrics come out in the order
+// they were registered.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+// register adds m, panicking on invalid or duplicate names since those are
+// programming errors.
+func (r *Registry) register(m metric) {
+	d := m.describe()
+	if !validName.MatchString(d.name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", d.name))
+	}
+	for _, l := range d.labels {
+		if !validName.MatchString(l) || strings.HasPrefix(l, "__") || strings.Contains(l, ":") {
+			panic(fmt.Sprintf("metrics: invalid label name %q on %s", l, d.name))
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[d.name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", d.name))
+	}
+	r.names[d.name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText renders every metric in the text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		d := m.describe()
+		fmt.Fprintf(bw, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", d.name, d.typ)
+		for _, s := range m.collect() {
+			bw.WriteString(d.name + s.suffix)
+			if len(s.labels) > 0 {
+				bw.WriteString("{")
+				for i, l := range s.labels {
+					if i > 0 {
+						bw.WriteString(",")
+					}
+					fmt.Fprintf(bw, `%s="%s"`, l.name, escapeLabel(l.value))
+				}
+				bw.WriteString("}")
+			}
+			bw.WriteString(" " + formatValue(s.value) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// series is the value kept for one combination of label values.
+type series struct {
+	labels []labelPair
+	value  float64
+}
+
+// seriesKey joins label values with a byte that can't appear in UTF-8
+// text.
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys makes output stable between scrapes.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
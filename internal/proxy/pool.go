@@ -0,0 +1,344 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/client"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+)
+
+var ErrNoHealthyBackends = errors.New("no healthy backends")
+
+const (
+	defaultMaxFails     = 3
+	defaultEjectTimeout = 30 * time.Second
+	hashReplicas        = 100
+)
+
+// Backend is a single upstream instance with its health bookkeeping.
+type Backend struct {
+	URL *url.URL
+
+	healthy      atomic.Bool
+	active       atomic.Int64
+	failures     atomic.Int32
+	ejectedUntil atomic.Int64
+}
+
+// Healthy reports whether the backend may receive traffic: it passed its
+// last active health check and is not passively ejected.
+func (b *Backend) Healthy() bool {
+	return b.healthy.Load() && time.Now().UnixNano() >= b.ejectedUntil.Load()
+}
+
+// ActiveRequests is the number of requests in flight to the backend.
+func (b *Backend) ActiveRequests() int64 {
+	return b.active.Load()
+}
+
+// Strategy picks one of the candidates for req that is not in exclude, or
+// returns nil when they are all excluded. Candidates are never empty and are
+// all healthy, exclude holds the backends a retry already tried.
+type Strategy interface {
+	Pick(candidates []*Backend, exclude map[*Backend]bool, req *request.Request) *Backend
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func RoundRobin() Strategy {
+	return &roundRobin{}
+}
+
+func (rr *roundRobin) Pick(candidates []*Backend, exclude map[*Backend]bool, _ *request.Request) *Backend {
+	n := rr.next.Add(1) - 1
+	for i := 0; i < len(candidates); i++ {
+		if b := candidates[(n+uint64(i))%uint64(len(candidates))]; !exclude[b] {
+			return b
+		}
+	}
+	return nil
+}
+
+type leastConnections struct{}
+
+func LeastConnections() Strategy {
+	return leastConnections{}
+}
+
+func (leastConnections) Pick(candidates []*Backend, exclude map[*Backend]bool, _ *request.Request) *Backend {
+	var best *Backend
+	for _, b := range candidates {
+		if exclude[b] {
+			continue
+		}
+		if best == nil || b.active.Load() < best.active.Load() {
+			best = b
+		}
+	}
+	return best
+}
+
+type ringNode struct {
+	hash    uint32
+	backend *Backend
+}
+
+// consistentHash maps the hash key of a request onto a ring of virtual nodes,
+// so adding or ejecting a backend only moves the keys that were on it.
+type consistentHash struct {
+	header string
+	mu     sync.Mutex
+	ring   []ringNode
+	built  []*Backend
+}
+
+// ConsistentHash routes requests with the same value of header to the same
+// backend. An empty header hashes the client IP instead.
+func ConsistentHash(header string) Strategy {
+	return &consistentHash{header: header}
+}
+
+func (ch *consistentHash) Pick(candidates []*Backend, exclude map[*Backend]bool, req *request.Request) *Backend {
+	key := clientIP(req.RemoteAddr)
+	if ch.header != "" {
+		if value, exists := req.Headers.Lookup(ch.header); exists {
+			key = value
+		}
+	}
+
+	ring := ch.ringFor(candidates)
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= hash })
+	// excluded backends are walked past, which sends their keys on to
+	// the next backend on the ring just like ejecting them would
+	for n := 0; n < len(ring); n++ {
+		if b := ring[(i+n)%len(ring)].backend; !exclude[b] {
+			return b
+		}
+	}
+	return nil
+}
+
+// ringFor builds the ring over the candidates, reusing the last one while
+// the set of healthy backends stays the same.
+func (ch *consistentHash) ringFor(candidates []*Backend) []ringNode {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if sameBackends(ch.built, candidates) {
+		return ch.ring
+	}
+
+	ring := make([]ringNode, 0, len(candidates)*hashReplicas)
+	for _, b := range candidates {
+		for replica := 0; replica < hashReplicas; replica++ {
+			hash := crc32.ChecksumIEEE([]byte(b.URL.String() + "#" + strconv.Itoa(replica)))
+			ring = append(ring, ringNode{hash: hash, backend: b})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	ch.ring = ring
+	ch.built = append(ch.built[:0], candidates...)
+	return ring
+}
+
+func sameBackends(a, b []*Backend) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type Pool struct {
+	backends     []*Backend
+	strategy     Strategy
+	maxFails     int32
+	ejectTimeout time.Duration
+
+	healthPath     string
+	healthInterval time.Duration
+	healthTimeout  time.Duration
+	healthClient   *client.Client
+	stop           chan struct{}
+	stopOnce       sync.Once
+}
+
+type PoolOption func(*Pool)
+
+// WithPassiveEjection takes a backend out of rotation for ejectTimeout
+// after maxFails consecutive failed requests.
+func WithPassiveEjection(maxFails int, ejectTimeout time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.maxFails = int32(maxFails)
+		p.ejectTimeout = ejectTimeout
+	}
+}
+
+// WithHealthCheck probes path under every backend's base path each interval.
+// Anything but a 2xx or 3xx answer within timeout marks the backend unhealthy
+// until the next successful probe.
+func WithHealthCheck(path string, interval, timeout time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.healthPath = path
+		p.healthInterval = interval
+		p.healthTimeout = timeout
+	}
+}
+
+func NewPool(upstreams []string, strategy Strategy, opts ...PoolOption) (*Pool, error) {
+	if len(upstreams) == 0 {
+		return nil, errors.New("pool needs at least one upstream")
+	}
+	p := &Pool{
+		strategy:     strategy,
+		maxFails:     defaultMaxFails,
+		ejectTimeout: defaultEjectTimeout,
+		stop:         make(chan struct{}),
+	}
+	for _, upstream := range upstreams {
+		u, err := parseUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		b := &Backend{URL: u}
+		b.healthy.Store(true)
+		p.backends = append(p.backends, b)
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.healthPath != "" && p.healthInterval > 0 {
+		if p.healthTimeout <= 0 {
+			p.healthTimeout = p.healthInterval
+		}
+		p.healthClient = &client.Client{Timeout: p.healthTimeout, MaxRedirects: -1}
+		p.checkHealth()
+		go p.healthLoop()
+	}
+	return p, nil
+}
+
+func parseUpstream(upstream string) (*url.URL, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse upstream %q: %w", upstream, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported upstream scheme: %q", u.Scheme)
+	}
+	return u, nil
+}
+
+func (p *Pool) Backends() []*Backend {
+	return p.backends
+}
+
+// Close stops the health checks.
+func (p *Pool) Close() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// next picks a healthy backend that is not in exclude.
+func (p *Pool) next(req *request.Request, exclude map[*Backend]bool) (*Backend, error) {
+	candidates := make([]*Backend, 0, len(p.backends))
+	for _, b := range p.backends {
+		if b.Healthy() {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoHealthyBackends
+	}
+	b := p.strategy.Pick(candidates, exclude, req)
+	if b == nil {
+		return nil, ErrNoHealthyBackends
+	}
+	return b, nil
+}
+
+func (p *Pool) reportSuccess(b *Backend) {
+	b.failures.Store(0)
+}
+
+func (p *Pool) reportFailure(b *Backend) {
+	if p.maxFails <= 0 {
+		return
+	}
+	if b.failures.Add(1) >= p.maxFails {
+		b.failures.Store(0)
+		b.ejectedUntil.Store(time.Now().Add(p.ejectTimeout).UnixNano())
+		log.Printf("Ejected backend %s for %s", b.URL.Host, p.ejectTimeout)
+	}
+}
+
+func (p *Pool) healthLoop() {
+	ticker := time.NewTicker(p.healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.checkHealth()
+		}
+	}
+}
+
+func (p *Pool) checkHealth() {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			healthy := p.probe(b)
+			if healthy && !b.healthy.Load() {
+				log.Printf("Backend %s is healthy again", b.URL.Host)
+			} else if !healthy && b.healthy.Load() {
+				log.Printf("Backend %s failed its health check", b.URL.Host)
+			}
+			// a passive ejection runs its course, the health path
+			// answering says little about the requests that failed
+			b.healthy.Store(healthy)
+		}(b)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) probe(b *Backend) bool {
+	// the health path lives under the base path, like proxied paths do
+	target := *b.URL
+	target.Path = strings.TrimSuffix(b.URL.Path, "/") + "/" + strings.TrimPrefix(p.healthPath, "/")
+	target.RawPath = ""
+	res, err := p.healthClient.Get(context.Background(), target.String())
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusCode >= 200 && res.StatusCode < 400
+}
+
+func clientIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
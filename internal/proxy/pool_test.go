@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBackend starts an upstream that answers with its name.
+func newBackend(name string, hits *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits != nil {
+			hits.Add(1)
+		}
+		w.Write([]byte(name))
+	}))
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, b := newBackend("a", nil), newBackend("b", nil)
+	defer a.Close()
+	defer b.Close()
+
+	pool, err := NewPool([]string{a.URL, b.URL}, RoundRobin())
+	require.NoError(t, err)
+	p := NewBalanced(pool)
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		_, body := serve(t, p, newProxyRequest("GET", "/", map[string]string{}, ""))
+		got = append(got, string(body))
+	}
+	assert.Equal(t, []string{"a", "b", "a", "b"}, got)
+}
+
+func TestLeastConnections(t *testing.T) {
+	pool, err := NewPool([]string{"http://one.invalid", "http://two.invalid", "http://three.invalid"}, LeastConnections())
+	require.NoError(t, err)
+	backends := pool.Backends()
+	backends[0].active.Store(3)
+	backends[1].active.Store(1)
+	backends[2].active.Store(2)
+
+	picked, err := pool.next(newProxyRequest("GET", "/", map[string]string{}, ""), nil)
+	require.NoError(t, err)
+	assert.Same(t, backends[1], picked)
+}
+
+func TestConsistentHash(t *testing.T) {
+	upstreams := []string{"http://one.invalid", "http://two.invalid", "http://three.invalid"}
+	pool, err := NewPool(upstreams, ConsistentHash("X-User"))
+	require.NoError(t, err)
+
+	// Test: The same key always lands on the same backend
+	req := newProxyRequest("GET", "/", map[string]string{"x-user": "alice"}, "")
+	first, err := pool.next(req, nil)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		again, err := pool.next(req, nil)
+		require.NoError(t, err)
+		assert.Same(t, first, again)
+	}
+
+	// Test: Keys spread over all backends
+	seen := map[*Backend]bool{}
+	for i := 0; i < 200; i++ {
+		req := newProxyRequest("GET", "/", map[string]string{"x-user": "user" + string(rune('a'+i%26)) + string(rune('a'+i/26))}, "")
+		b, err := pool.next(req, nil)
+		require.NoError(t, err)
+		seen[b] = true
+	}
+	assert.Len(t, seen, 3)
+
+	// Test: Ejecting a backend only moves its own keys
+	moved, stayed := 0, 0
+	before := map[string]*Backend{}
+	for i := 0; i < 100; i++ {
+		key := "key" + string(rune('a'+i%26)) + string(rune('a'+i/26))
+		b, _ := pool.next(newProxyRequest("GET", "/", map[string]string{"x-user": key}, ""), nil)
+		before[key] = b
+	}
+	ejected := pool.Backends()[0]
+	ring := pool.strategy.(*consistentHash).ring
+	for key, b := range before {
+		after, _ := pool.next(newProxyRequest("GET", "/", map[string]string{"x-user": key}, ""), map[*Backend]bool{ejected: true})
+		if b != ejected {
+			if after == b {
+				stayed++
+			} else {
+				moved++
+			}
+		}
+	}
+	assert.Zero(t, moved)
+	assert.NotZero(t, stayed)
+	// the ring over the healthy backends was reused, not rebuilt
+	assert.Same(t, &ring[0], &pool.strategy.(*consistentHash).ring[0])
+
+	// Test: Without a header the client IP is the key
+	pool, err = NewPool(upstreams, ConsistentHash(""))
+	require.NoError(t, err)
+	one := newProxyRequest("GET", "/", map[string]string{}, "")
+	other := newProxyRequest("GET", "/other", map[string]string{}, "")
+	other.RemoteAddr = "203.0.113.7:1"
+	b1, _ := pool.next(one, nil)
+	b2, _ := pool.next(other, nil)
+	assert.Same(t, b1, b2)
+}
+
+func TestPassiveEjectionAndRetry(t *testing.T) {
+	var hits atomic.Int32
+	healthy := newBackend("healthy", &hits)
+	defer healthy.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	pool, err := NewPool([]string{dead.URL, healthy.URL}, RoundRobin(), WithPassiveEjection(2, time.Hour))
+	require.NoError(t, err)
+	p := NewBalanced(pool)
+
+	// Test: Idempotent requests are retried on another backend
+	for i := 0; i < 4; i++ {
+		res, body := serve(t, p, newProxyRequest("GET", "/", map[string]string{}, ""))
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "healthy", string(body))
+	}
+	assert.Equal(t, int32(4), hits.Load())
+
+	// Test: The dead backend got ejected after two failures
+	assert.False(t, pool.Backends()[0].Healthy())
+	assert.True(t, pool.Backends()[1].Healthy())
+
+	// Test: POST is not retried
+	pool, err = NewPool([]string{dead.URL, healthy.URL}, RoundRobin())
+	require.NoError(t, err)
+	p = NewBalanced(pool)
+	res, _ := serve(t, p, newProxyRequest("POST", "/", map[string]string{}, "payload"))
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+}
+
+func TestActiveHealthChecks(t *testing.T) {
+	var up atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" && !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	pool, err := NewPool([]string{backend.URL}, RoundRobin(), WithHealthCheck("/healthz", 10*time.Millisecond, time.Second))
+	require.NoError(t, err)
+	defer pool.Close()
+
+	// Test: The first check runs before the pool is handed out
+	assert.False(t, pool.Backends()[0].Healthy())
+	res, _ := serve(t, NewBalanced(pool), newProxyRequest("GET", "/", map[string]string{}, ""))
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+	// Test: The backend comes back once its health check passes
+	up.Store(true)
+	assert.Eventually(t, pool.Backends()[0].Healthy, time.Second, 5*time.Millisecond)
+	// Test: A passing probe doesn't end a passive ejection
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer failing.Close()
+	pool, err = NewPool([]string{failing.URL}, RoundRobin(),
+		WithHealthCheck("/healthz", 10*time.Millisecond, time.Second), WithPassiveEjection(1, time.Hour))
+	require.NoError(t, err)
+	defer pool.Close()
+	res, _ = serve(t, NewBalanced(pool), newProxyRequest("GET", "/", map[string]string{}, ""))
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+	assert.Never(t, pool.Backends()[0].Healthy, 100*time.Millisecond, 5*time.Millisecond)
+
+	// Test: The health path is probed under the backend's base path
+	var probed atomic.Value
+	based := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probed.Store(r.URL.Path)
+	}))
+	defer based.Close()
+	pool, err = NewPool([]string{based.URL + "/api/"}, RoundRobin(), WithHealthCheck("/healthz", 10*time.Millisecond, time.Second))
+	require.NoError(t, err)
+	defer pool.Close()
+	assert.True(t, pool.Backends()[0].Healthy())
+	assert.Equal(t, "/api/healthz", probed.Load())
+}
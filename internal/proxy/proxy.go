@@ -0,0 +1,413 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/client"
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/requestid"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/tracing"
+)
+
+// hopByHopHeaders only make sense for a single connection and are never
+// forwarded, in either direction.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+const defaultTimeout = 30 * time.Second
+const defaultRetries = 2
+const relayBufferSize = 32 * 1024
+
+type Proxy struct {
+	pool        *Pool
+	stripPrefix string
+	retries     int
+	timeout     time.Duration
+	// trailers switches the response relay to chunked framing with
+	// X-Content-SHA256 and X-Content-Length trailers
+	trailers bool
+	client   *client.Client
+	tracer   *tracing.Tracer
+}
+
+type Option func(*Proxy)
+
+// WithStripPrefix removes prefix from the request target before it is
+// appended to the upstream URL.
+func WithStripPrefix(prefix string) Option {
+	return func(p *Proxy) { p.stripPrefix = prefix }
+}
+
+// WithTimeout bounds connecting to a backend and waiting for its response
+// head, a timed out head turns into a 504. The body is not bounded as a
+// whole, only each read of it.
+func WithTimeout(timeout time.Duration) Option {
+	return func(p *Proxy) { p.timeout = timeout }
+}
+
+// WithTrailers relays the upstream body as chunks, followed by SHA-256 and
+// length trailers computed over what was sent.
+func WithTrailers() Option {
+	return func(p *Proxy) { p.trailers = true }
+}
+
+// WithRetries lets idempotent requests that failed to reach a backend be
+// retried up to n times, each time on a backend not tried yet.
+func WithRetries(n int) Option {
+	return func(p *Proxy) { p.retries = n }
+}
+
+// WithTracer records a client span for every attempt at a backend. The
+// trace context of the request is forwarded upstream either way.
+func WithTracer(t *tracing.Tracer) Option {
+	return func(p *Proxy) { p.tracer = t }
+}
+
+// New proxies to a single upstream.
+func New(upstream string, opts ...Option) (*Proxy, error) {
+	pool, err := NewPool([]string{upstream}, RoundRobin(), WithPassiveEjection(0, 0))
+	if err != nil {
+		return nil, err
+	}
+	return NewBalanced(pool, opts...), nil
+}
+
+// NewBalanced spreads requests over the backends of pool.
+func NewBalanced(pool *Pool, opts ...Option) *Proxy {
+	p := &Proxy{
+		pool:    pool,
+		retries: defaultRetries,
+		timeout: defaultTimeout,
+		// redirects are the client's business, not ours
+		client: &client.Client{MaxRedirects: -1},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Handle forwards req to a backend and relays the response back. It has the
+// signature of a server.Handler.
+func (p *Proxy) Handle(w *response.Writer, req *request.Request) {
+	// a client hanging up cancels the upstream request too
+	ctx := req.Context()
+
+	tried := map[*Backend]bool{}
+	for attempt := 0; ; attempt++ {
+		backend, err := p.pool.next(req, tried)
+		if err != nil {
+			log.Printf("Couldn't pick a backend: %v", err)
+			if attempt > 0 {
+				// every backend we could try failed
+				response.WriteError(w, response.BadGateway, "")
+				return
+			}
+			response.WriteError(w, response.ServiceUnavailable, "")
+			return
+		}
+		tried[backend] = true
+
+		outReq, err := p.outboundRequest(req, backend)
+		if err != nil {
+			log.Printf("Couldn't build upstream request: %v", err)
+			response.WriteError(w, response.BadRequest, "")
+			return
+		}
+
+		attemptCtx, span := p.startSpan(ctx, req, backend, attempt)
+		tracing.Inject(attemptCtx, outReq.Headers)
+		attemptCtx, cancelAttempt := context.WithCancel(attemptCtx)
+		// the client gives up on the connection once attemptCtx is
+		// cancelled, whether that's before the head or mid-body
+		timer := time.AfterFunc(p.timeout, cancelAttempt)
+
+		backend.active.Add(1)
+		res, err := p.client.Do(attemptCtx, outReq)
+		timedOut := !timer.Stop()
+		if err == nil && timedOut {
+			res.Body.Close()
+			err = context.DeadlineExceeded
+		}
+		if err != nil {
+			cancelAttempt()
+			backend.active.Add(-1)
+			p.pool.reportFailure(backend)
+			log.Printf("Couldn't get a response from %s: %v", backend.URL.Host, err)
+			span.SetError(err)
+			span.End()
+			if req.Context().Err() != nil {
+				// nobody is left to answer
+				return
+			}
+
+			var netErr net.Error
+			if timedOut || errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+				response.WriteError(w, response.GatewayTimeout, "")
+				return
+			}
+			if attempt < p.retries && isIdempotent(req.RequestLine.Method) {
+				continue
+			}
+			response.WriteError(w, response.BadGateway, "")
+			return
+		}
+
+		if res.StatusCode == 502 || res.StatusCode == 503 || res.StatusCode == 504 {
+			p.pool.reportFailure(backend)
+		} else {
+			p.pool.reportSuccess(backend)
+		}
+		span.SetAttribute("http.response.status_code", fmt.Sprint(res.StatusCode))
+		res.Body = &idleTimeoutBody{ReadCloser: res.Body, timer: timer, timeout: p.timeout}
+		err = p.relay(w, req, res)
+		res.Body.Close()
+		cancelAttempt()
+		backend.active.Add(-1)
+		if err != nil {
+			log.Printf("Couldn't relay response from %s: %v", backend.URL.Host, err)
+			span.SetError(err)
+		} else if res.StatusCode >= 500 {
+			span.SetError(fmt.Errorf("status %d", res.StatusCode))
+		}
+		span.End()
+		return
+	}
+}
+
+// idleTimeoutBody arms timer around every read, so a body that keeps
+// trickling in may take as long as it needs while one that stalls for
+// timeout is cut off.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	return n, err
+}
+
+// startSpan starts the client span for one attempt, or returns ctx and a nil
+// span without a tracer.
+func (p *Proxy) startSpan(ctx context.Context, req *request.Request, backend *Backend, attempt int) (context.Context, *tracing.Span) {
+	if p.tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := p.tracer.Start(ctx, req.RequestLine.Method+" "+backend.URL.Host, tracing.KindClient)
+	span.SetAttribute("http.request.method", req.RequestLine.Method)
+	span.SetAttribute("server.address", backend.URL.Host)
+	span.SetAttribute("proxy.attempt", fmt.Sprint(attempt))
+	return ctx, span
+}
+
+// isIdempotent reports whether sending the request twice is harmless, which
+// is what makes retrying it on another backend safe.
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func (p *Proxy) targetURL(backend *Backend, requestTarget string) string {
+	target := *backend.URL
+	path, rawQuery, _ := strings.Cut(strings.TrimPrefix(requestTarget, p.stripPrefix), "?")
+	target.Path = strings.TrimSuffix(backend.URL.Path, "/") + "/" + strings.TrimPrefix(path, "/")
+	target.RawPath = ""
+	target.RawQuery = rawQuery
+	return target.String()
+}
+
+func (p *Proxy) outboundRequest(req *request.Request, backend *Backend) (*client.Request, error) {
+	outReq, err := client.NewRequest(req.RequestLine.Method,
+		p.targetURL(backend, req.RequestLine.RequestTarget), req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	connectionHeaders := connectionTokens(req.Headers)
+	for key, value := range req.Headers {
+		if isHopByHop(key, connectionHeaders) || strings.EqualFold(key, "Host") {
+			continue
+		}
+		outReq.Headers.Set(key, value)
+	}
+
+	clientIP := clientIP(req.RemoteAddr)
+	host, _ := req.Headers.Lookup("Host")
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	if clientIP != "" {
+		if prior, exists := req.Headers.Lookup("X-Forwarded-For"); exists {
+			outReq.Headers.Set("X-Forwarded-For", prior+", "+clientIP)
+		} else {
+			outReq.Headers.Set("X-Forwarded-For", clientIP)
+		}
+	}
+	if host != "" {
+		outReq.Headers.Set("X-Forwarded-Host", host)
+	}
+	outReq.Headers.Set("X-Forwarded-Proto", proto)
+
+	forwarded := forwardedElement(clientIP, host, proto)
+	if prior, exists := req.Headers.Lookup("Forwarded"); exists {
+		forwarded = prior + ", " + forwarded
+	}
+	outReq.Headers.Set("Forwarded", forwarded)
+
+	// the backend logs under the same ID we do
+	if id := requestid.FromContext(req.Context()); id != "" {
+		outReq.Headers.Set(requestid.Header, id)
+	}
+	return outReq, nil
+}
+
+// forwardedElement builds one RFC 7239 element. IPv6 addresses and anything
+// with a colon have to be quoted.
+func forwardedElement(clientIP, host, proto string) string {
+	parts := make([]string, 0, 3)
+	if clientIP != "" {
+		if strings.Contains(clientIP, ":") {
+			parts = append(parts, fmt.Sprintf(`for="[%s]"`, clientIP))
+		} else {
+			parts = append(parts, "for="+clientIP)
+		}
+	}
+	if host != "" {
+		parts = append(parts, fmt.Sprintf("host=%q", host))
+	}
+	parts = append(parts, "proto="+proto)
+	return strings.Join(parts, ";")
+}
+
+func (p *Proxy) relay(w *response.Writer, req *request.Request, res *client.Response) error {
+	h := response.GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Delete("Content-Type")
+	connectionHeaders := connectionTokens(res.Headers)
+	for key, value := range res.Headers {
+		if isHopByHop(key, connectionHeaders) {
+			continue
+		}
+		h.Set(key, value)
+	}
+
+	if err := w.WriteStatusLine(response.StatusCode(res.StatusCode)); err != nil {
+		return err
+	}
+
+	if req.RequestLine.Method == "HEAD" || res.StatusCode == 204 || res.StatusCode == 304 {
+		if res.ContentLength >= 0 {
+			h.Set("Content-Length", fmt.Sprint(res.ContentLength))
+		}
+		return w.WriteHeaders(h)
+	}
+
+	if !p.trailers && res.ContentLength >= 0 {
+		h.Set("Content-Length", fmt.Sprint(res.ContentLength))
+		if err := w.WriteHeaders(h); err != nil {
+			return err
+		}
+		_, err := w.WriteBodyFrom(res.Body)
+		return err
+	}
+
+	// the upstream's length, if it sent one, can't go along with chunks
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	if p.trailers {
+		h.Set("Trailer", "X-Content-SHA256, X-Content-Length")
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+
+	checkSum := sha256.New()
+	var totalBytes int64
+	buf := make([]byte, relayBufferSize)
+	for {
+		n, err := res.Body.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.WriteChunkedBody(buf[:n]); writeErr != nil {
+				return fmt.Errorf("couldn't write chunk: %w", writeErr)
+			}
+			if flushErr := w.Flush(); flushErr != nil {
+				return fmt.Errorf("couldn't flush chunk: %w", flushErr)
+			}
+			checkSum.Write(buf[:n])
+			totalBytes += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// the status line is long gone, all that's left is making
+			// sure the client doesn't take the body for complete
+			w.Abort()
+			return fmt.Errorf("couldn't read upstream body: %w", err)
+		}
+	}
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+
+	t := headers.NewHeaders()
+	if p.trailers {
+		t["X-Content-SHA256"] = fmt.Sprintf("%x", checkSum.Sum(nil))
+		t["X-Content-Length"] = fmt.Sprint(totalBytes)
+	}
+	return w.WriteTrailers(t)
+}
+
+// connectionTokens collects the extra hop-by-hop headers named by the
+// Connection header.
+func connectionTokens(h headers.Headers) map[string]bool {
+	tokens := map[string]bool{}
+	value, exists := h.Lookup("Connection")
+	if !exists {
+		return tokens
+	}
+	for _, token := range strings.Split(value, ",") {
+		tokens[strings.ToLower(strings.TrimSpace(token))] = true
+	}
+	return tokens
+}
+
+func isHopByHop(key string, connectionHeaders map[string]bool) bool {
+	if connectionHeaders[strings.ToLower(key)] {
+		return true
+	}
+	for _, hopByHop := range hopByHopHeaders {
+		if strings.EqualFold(key, hopByHop) {
+			return true
+		}
+	}
+	return false
+}
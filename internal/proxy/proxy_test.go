@@ -0,0 +1,315 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/requestid"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/server"
+	"github.com/felixsolom/http-from-tcp/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newProxyRequest(method, target string, h map[string]string, body string) *request.Request {
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     headers.Headers(h),
+		Body:        []byte(body),
+		RemoteAddr:  "203.0.113.7:51234",
+	}
+}
+
+// record runs the proxy for req and returns what it wrote back.
+func record(t *testing.T, p *Proxy, req *request.Request) *bytes.Buffer {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	p.Handle(w, req)
+	require.NoError(t, w.Finish())
+	return &buf
+}
+
+// serve runs the proxy for req and parses what it wrote back.
+func serve(t *testing.T, p *Proxy, req *request.Request) (*http.Response, []byte) {
+	res, err := http.ReadResponse(bufio.NewReader(record(t, p, req)), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, body
+}
+
+func TestProxyForwardsRequest(t *testing.T) {
+	var seen *http.Request
+	var seenBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+		seenBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("X-Upstream", "yes")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL+"/base/", WithStripPrefix("/api/"))
+	require.NoError(t, err)
+
+	res, body := serve(t, p, newProxyRequest("POST", "/api/items?limit=2", map[string]string{
+		"host":            "localhost:42069",
+		"content-type":    "application/json",
+		"content-length":  "11",
+		"connection":      "close, X-Secret",
+		"x-secret":        "hop",
+		"x-forwarded-for": "198.51.100.1",
+		"upgrade":         "websocket",
+	}, `{"a":"b"}`+"\n\n"))
+
+	// Test: Method, path, query, headers and body reach the upstream
+	require.NotNil(t, seen)
+	assert.Equal(t, "POST", seen.Method)
+	assert.Equal(t, "/base/items", seen.URL.Path)
+	assert.Equal(t, "limit=2", seen.URL.RawQuery)
+	assert.Equal(t, "application/json", seen.Header.Get("Content-Type"))
+	assert.Equal(t, "{\"a\":\"b\"}\n\n", string(seenBody))
+
+	// Test: Hop-by-hop headers are stripped, including ones named by Connection
+	assert.Empty(t, seen.Header.Get("X-Secret"))
+	assert.Empty(t, seen.Header.Get("Upgrade"))
+
+	// Test: Forwarding headers
+	assert.Equal(t, "198.51.100.1, 203.0.113.7", seen.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "localhost:42069", seen.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "http", seen.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, `for=203.0.113.7;host="localhost:42069";proto=http`, seen.Header.Get("Forwarded"))
+
+	// Test: Response comes back with its status and without hop-by-hop headers
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "yes", res.Header.Get("X-Upstream"))
+	assert.Empty(t, res.Header.Get("Keep-Alive"))
+	assert.Equal(t, int64(7), res.ContentLength)
+	assert.Equal(t, "created", string(body))
+}
+
+func TestProxyTrailers(t *testing.T) {
+	payload := strings.Repeat("streamed ", 10000)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(payload))
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL, WithTrailers())
+	require.NoError(t, err)
+
+	res, body := serve(t, p, newProxyRequest("GET", "/stream", map[string]string{}, ""))
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, payload, string(body))
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte(payload))), res.Trailer.Get("X-Content-SHA256"))
+	assert.Equal(t, fmt.Sprint(len(payload)), res.Trailer.Get("X-Content-Length"))
+
+	// Test: An upstream Content-Length doesn't go out next to chunked
+	sized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5")
+		w.Write([]byte("sized"))
+	}))
+	defer sized.Close()
+	p, err = New(sized.URL, WithTrailers())
+	require.NoError(t, err)
+	raw := record(t, p, newProxyRequest("GET", "/", map[string]string{}, "")).String()
+	head, _, _ := strings.Cut(raw, "\r\n\r\n")
+	assert.Contains(t, head, "Transfer-Encoding: chunked")
+	assert.NotContains(t, strings.ToLower(head), "\r\ncontent-length:")
+}
+
+func TestProxyUpstreamFailures(t *testing.T) {
+	// Test: Nothing listening upstream
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+	res, _ := serve(t, p, newProxyRequest("GET", "/", map[string]string{}, ""))
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+
+	// Test: Upstream too slow
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	p, err = New(slow.URL, WithTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	res, _ = serve(t, p, newProxyRequest("GET", "/", map[string]string{}, ""))
+	assert.Equal(t, http.StatusGatewayTimeout, res.StatusCode)
+
+	// Test: A body that keeps trickling in may outlast the timeout
+	steady := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 8; i++ {
+			w.Write([]byte("tick\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer steady.Close()
+	p, err = New(steady.URL, WithTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	res, body := serve(t, p, newProxyRequest("GET", "/", map[string]string{}, ""))
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, strings.Repeat("tick\n", 8), string(body))
+
+	// Test: A body that stalls is cut off
+	stall := make(chan struct{})
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tick\n"))
+		w.(http.Flusher).Flush()
+		<-stall
+	}))
+	defer stalled.Close()
+	defer close(stall)
+	p, err = New(stalled.URL, WithTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	raw := record(t, p, newProxyRequest("GET", "/", map[string]string{}, ""))
+	assert.False(t, strings.HasSuffix(raw.String(), "0\r\n\r\n"))
+	res, err = http.ReadResponse(bufio.NewReader(raw), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body, err = io.ReadAll(res.Body)
+	// the client can tell the body is incomplete
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, "tick\n", string(body))
+
+	// Test: Bad upstream URLs
+	_, err = New("ftp://example.com")
+	require.Error(t, err)
+}
+
+type spanRecorder struct {
+	spans []*tracing.Span
+}
+
+func (r *spanRecorder) Export(span *tracing.Span) error {
+	r.spans = append(r.spans, span)
+	return nil
+}
+
+func TestProxyTracing(t *testing.T) {
+	var seen string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get("Traceparent")
+	}))
+	defer upstream.Close()
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	// Test: Without a server span the incoming traceparent passes through
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+	serve(t, p, newProxyRequest("GET", "/", map[string]string{"traceparent": incoming}, ""))
+	assert.Equal(t, incoming, seen)
+
+	// Test: The hop gets a client span whose ID is the upstream's parent
+	exported := &spanRecorder{}
+	tracer := tracing.NewTracer(exported)
+	p, err = New(upstream.URL, WithTracer(tracer))
+	require.NoError(t, err)
+	sc, err := tracing.ParseTraceparent(incoming)
+	require.NoError(t, err)
+	ctx, server := tracer.Start(tracing.ContextWithRemote(context.Background(), sc), "server", tracing.KindServer)
+	serve(t, p, newProxyRequest("GET", "/", map[string]string{"traceparent": incoming}, "").WithContext(ctx))
+	server.End()
+
+	require.Len(t, exported.spans, 2)
+	hop := exported.spans[0]
+	assert.Equal(t, tracing.KindClient, hop.Kind)
+	assert.Equal(t, server.SpanID, hop.Parent)
+	assert.Equal(t, "200", hop.Attributes["http.response.status_code"])
+	got, err := tracing.ParseTraceparent(seen)
+	require.NoError(t, err)
+	assert.Equal(t, sc.TraceID, got.TraceID)
+	assert.Equal(t, hop.SpanID, got.SpanID)
+}
+
+func TestProxyRequestID(t *testing.T) {
+	var seen string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get("X-Request-ID")
+		w.Header().Set("X-Request-ID", "upstream-id")
+	}))
+	defer upstream.Close()
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	requestid.Middleware(p.Handle)(w, newProxyRequest("GET", "/", map[string]string{"x-request-id": "bad id"}, ""))
+	require.NoError(t, w.Finish())
+	res, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+	require.NoError(t, err)
+
+	// Test: The backend gets our ID, and the client gets it back
+	assert.True(t, requestid.Valid(seen))
+	assert.NotEqual(t, "bad id", seen)
+	assert.Equal(t, seen, res.Header.Get("X-Request-ID"))
+}
+
+// writeTestCert writes the certificate and key httptest serves TLS with to
+// dir, for a server.WithTLS listener.
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	cert := ts.TLS.Certificates[0]
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+	return certFile, keyFile
+}
+
+func TestProxyOverTLS(t *testing.T) {
+	var seen *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+	}))
+	defer upstream.Close()
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+	s, err := server.Serve(0, p.Handle, server.WithLocalhostOnly(), server.WithTLS(certFile, keyFile))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(raw), "HTTP/1.1 200 OK\r\n"), string(raw))
+
+	// Test: The backend learns the client came in over TLS
+	require.NotNil(t, seen)
+	assert.Equal(t, "https", seen.Header.Get("X-Forwarded-Proto"))
+	assert.Contains(t, seen.Header.Get("Forwarded"), ";proto=https")
+}
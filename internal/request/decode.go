@@ -0,0 +1,82 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported content-coding")
+	ErrBodyTooLarge        = errors.New("decoded body too large")
+)
+
+// DecodeBody undoes the gzip or deflate content-codings listed in
+// Content-Encoding, replacing Body with the decoded bytes and fixing up the
+// Content-Encoding and Content-Length headers. Decoding stops with
+// ErrBodyTooLarge once more than maxSize bytes come out, which keeps a tiny
+// zip bomb from eating all the memory.
+func (r *Request) DecodeBody(maxSize int64) error {
+	contentEncoding, exists := r.Headers.Get("Content-Encoding")
+	if !exists {
+		return nil
+	}
+
+	codings := strings.Split(contentEncoding, ",")
+	body := r.Body
+	// codings are listed in the order they were applied, so undo them
+	// back to front
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.TrimSpace(codings[i])
+		if coding == "" || coding == "identity" {
+			continue
+		}
+		decoded, err := decodeBody(coding, body, maxSize)
+		if err != nil {
+			return err
+		}
+		body = decoded
+	}
+
+	r.Body = body
+	r.Headers.Delete("Content-Encoding")
+	r.Headers.Override("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+func decodeBody(coding string, body []byte, maxSize int64) ([]byte, error) {
+	var decoder io.ReadCloser
+	var err error
+	switch coding {
+	case "gzip", "x-gzip":
+		decoder, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// "deflate" is supposed to be zlib wrapped, but plenty of clients
+		// send a raw deflate stream instead
+		decoder, err = zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			decoder, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, coding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode %s body: %w", coding, err)
+	}
+	defer decoder.Close()
+
+	decoded, err := io.ReadAll(io.LimitReader(decoder, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode %s body: %w", coding, err)
+	}
+	if int64(len(decoded)) > maxSize {
+		return nil, ErrBodyTooLarge
+	}
+	return decoded, nil
+}
@@ -0,0 +1,84 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"strings"
+	"testing"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEncodedRequest(contentEncoding string, body []byte) *Request {
+	h := headers.NewHeaders()
+	h["content-encoding"] = contentEncoding
+	h["content-length"] = "0"
+	return &Request{Headers: h, Body: body}
+}
+
+func TestDecodeBody(t *testing.T) {
+	logs := []byte(strings.Repeat("level=info msg=\"uploaded\"\n", 50))
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(logs)
+	gw.Close()
+
+	var zlibbed bytes.Buffer
+	zw := zlib.NewWriter(&zlibbed)
+	zw.Write(logs)
+	zw.Close()
+
+	var raw bytes.Buffer
+	fw, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	fw.Write(logs)
+	fw.Close()
+
+	// Test: gzip body
+	r := newEncodedRequest("gzip", gzipped.Bytes())
+	require.NoError(t, r.DecodeBody(1<<20))
+	assert.Equal(t, logs, r.Body)
+	_, exists := r.Headers.Get("Content-Encoding")
+	assert.False(t, exists)
+	assert.Equal(t, "1300", r.Headers["content-length"])
+
+	// Test: zlib wrapped and raw deflate bodies
+	r = newEncodedRequest("deflate", zlibbed.Bytes())
+	require.NoError(t, r.DecodeBody(1<<20))
+	assert.Equal(t, logs, r.Body)
+	r = newEncodedRequest("deflate", raw.Bytes())
+	require.NoError(t, r.DecodeBody(1<<20))
+	assert.Equal(t, logs, r.Body)
+
+	// Test: Stacked codings are undone in reverse order
+	var stacked bytes.Buffer
+	gw = gzip.NewWriter(&stacked)
+	gw.Write(zlibbed.Bytes())
+	gw.Close()
+	r = newEncodedRequest("deflate, gzip", stacked.Bytes())
+	require.NoError(t, r.DecodeBody(1<<20))
+	assert.Equal(t, logs, r.Body)
+
+	// Test: No Content-Encoding leaves the body alone
+	r = &Request{Headers: headers.NewHeaders(), Body: []byte("plain")}
+	require.NoError(t, r.DecodeBody(1))
+	assert.Equal(t, "plain", string(r.Body))
+
+	// Test: Decoded size limit
+	r = newEncodedRequest("gzip", gzipped.Bytes())
+	assert.ErrorIs(t, r.DecodeBody(100), ErrBodyTooLarge)
+
+	// Test: Unknown coding
+	r = newEncodedRequest("br", []byte("whatever"))
+	assert.ErrorIs(t, r.DecodeBody(1<<20), ErrUnsupportedEncoding)
+
+	// Test: Corrupt gzip
+	r = newEncodedRequest("gzip", []byte("not gzip at all"))
+	err := r.DecodeBody(1 << 20)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnsupportedEncoding)
+}
@@ -2,23 +2,73 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/felixsolom/http-from-tcp/internal/headers"
 )
 
 type Request struct {
-	RequestLine    RequestLine
-	ParserState    ParserState
-	Headers        headers.Headers
-	Body           []byte
+	RequestLine RequestLine
+	ParserState ParserState
+	Headers     headers.Headers
+	Body        []byte
+	// RemoteAddr is the address of the peer that sent the request, filled
+	// in by the server.
+	RemoteAddr string
+	// LocalAddr is the server's address the request arrived on.
+	LocalAddr string
+	// ConnID tells the server's connections apart and Seq counts the
+	// requests on one, starting at 1. HTTP/1.1 connections carry a single
+	// request, HTTP/2 ones a request per stream.
+	ConnID uint64
+	Seq    uint64
+	// ReceivedAt is when the request line was read, or for HTTP/2 the
+	// header block.
+	ReceivedAt time.Time
+	// PeerCred identifies the process on the other end of a Unix socket
+	// connection, nil for TCP or where the platform can't tell.
+	PeerCred *Credentials
+	// TLS describes the connection the request arrived on when it was
+	// HTTPS, including any verified client certificate chains.
+	TLS            *tls.ConnectionState
 	bodyLengthRead int
+	ctx            context.Context
+}
+
+// Context is cancelled once the handler returned, the client went away or
+// the server is shutting down. Requests that weren't given one get
+// context.Background.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r carrying ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("request: nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// Credentials of a local peer, as reported by the kernel.
+type Credentials struct {
+	PID int32
+	UID uint32
+	GID uint32
 }
 
 type RequestLine struct {
@@ -37,10 +87,26 @@ const (
 	stateDone
 ) // End of Enum init
 
+// Kinds of parse errors, for telling them apart with errors.Is.
+var (
+	ErrIncomplete           = errors.New("incomplete request")
+	ErrMalformedRequestLine = errors.New("malformed request line")
+	ErrMalformedHeader      = errors.New("malformed header")
+	ErrBadContentLength     = errors.New("bad content length")
+)
+
 const crlf = "\r\n"
 const bufferSize = 8
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	r, _, err := ReadRequest(reader)
+	return r, err
+}
+
+// ReadRequest parses one request like RequestFromReader and also returns
+// what was read past its end: the start of a pipelined request, or of
+// another protocol after an upgrade.
+func ReadRequest(reader io.Reader) (*Request, []byte, error) {
 	buff := make([]byte, bufferSize)
 	readToIndex := 0
 
@@ -61,11 +127,11 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				if r.ParserState != stateDone {
-					return nil, fmt.Errorf("Incomplete request, in %d, read n bytes on EOF: %d", r.ParserState, numOfBytesRead)
+					return nil, nil, fmt.Errorf("%w, in %d, read n bytes on EOF: %d", ErrIncomplete, r.ParserState, numOfBytesRead)
 				}
 				break
 			}
-			return nil, err
+			return nil, nil, err
 		}
 		readToIndex += numOfBytesRead
 
@@ -76,7 +142,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 
 		numOfBytesParsed, parseErr := r.parse(buff[:readToIndex])
 		if parseErr != nil {
-			return nil, fmt.Errorf("couldn't parse from buffer: %w", parseErr)
+			return nil, nil, fmt.Errorf("couldn't parse from buffer: %w", parseErr)
 		}
 
 		// Shifting the yet unparsed data to the beginning of the buffer.
@@ -89,7 +155,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 			break
 		}
 	}
-	return &r, nil
+	return &r, buff[:readToIndex], nil
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -113,20 +179,21 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	case stateInitialized:
 		reqLine, numOfBytesParsed, err := parseRequestLine(data)
 		if err != nil {
-			return 0, err
+			return 0, fmt.Errorf("%w: %w", ErrMalformedRequestLine, err)
 		}
 		if numOfBytesParsed == 0 {
 			//not enough data, waiting for more
 			return 0, nil
 		}
 		r.RequestLine = *reqLine
+		r.ReceivedAt = time.Now()
 		r.ParserState = stateParsingHeaders
 		return numOfBytesParsed, nil
 
 	case stateParsingHeaders:
 		numOfBytesParsed, done, err := r.Headers.Parse(data)
 		if err != nil {
-			return 0, fmt.Errorf("Couldn't parse headers: %w", err)
+			return 0, fmt.Errorf("%w: %w", ErrMalformedHeader, err)
 		}
 
 		if done {
@@ -138,23 +205,26 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		contentLength, exists := r.Headers.Get("Content-Length")
 		if !exists {
 			r.ParserState = stateDone
-			return len(data), nil
+			return 0, nil
 		}
 
 		expectedBodyLength, err := strconv.Atoi(strings.TrimSpace(contentLength))
 		if err != nil {
-			return 0, fmt.Errorf("Failed to covert body length to integer: %w", err)
+			return 0, fmt.Errorf("%w: %w", ErrBadContentLength, err)
 		}
-
-		r.Body = append(r.Body, data...)
-		r.bodyLengthRead += len(data)
-		if r.bodyLengthRead > expectedBodyLength {
-			return 0, fmt.Errorf("Body larger than its declared length")
+		if expectedBodyLength < 0 {
+			return 0, fmt.Errorf("%w: negative length %d", ErrBadContentLength, expectedBodyLength)
 		}
+
+		// anything past the declared length belongs to whatever follows
+		// the request
+		chunk := data[:min(len(data), expectedBodyLength-r.bodyLengthRead)]
+		r.Body = append(r.Body, chunk...)
+		r.bodyLengthRead += len(chunk)
 		if r.bodyLengthRead == expectedBodyLength {
 			r.ParserState = stateDone
 		}
-		return len(data), nil
+		return len(chunk), nil
 
 	case stateDone:
 		return 0, fmt.Errorf("Trying to read data in Done state")
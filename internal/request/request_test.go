@@ -1,9 +1,11 @@
 package request
 
 import (
+	"context"
 	"io"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -271,3 +273,76 @@ func TestParsingBody(t *testing.T) {
 	require.NotNil(t, r)
 	assert.Equal(t, "", string(r.Body))
 }
+
+func TestReadRequestRest(t *testing.T) {
+	// Test: Bytes after the body are left over
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"helloGET /next HTTP/1.1\r\n",
+		numBytesPerRead: 1024,
+	}
+	r, rest, err := ReadRequest(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+	// how much is read ahead depends on the parser's buffer, but it's
+	// never nothing and always the start of the next request
+	require.NotEmpty(t, rest)
+	assert.True(t, strings.HasPrefix("GET /next HTTP/1.1\r\n", string(rest)), string(rest))
+
+	// Test: Without a body everything after the headers is left over
+	reader = &chunkReader{
+		data: "GET /ws HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n" +
+			"\x81\x05hello",
+		numBytesPerRead: 1024,
+	}
+	r, rest, err = ReadRequest(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "", string(r.Body))
+	assert.Equal(t, "\x81\x05hello", string(rest))
+}
+
+func TestContext(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: Background until one is set
+	assert.Equal(t, context.Background(), r.Context())
+
+	// Test: WithContext copies, the original keeps its context
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "user-7")
+	r2 := r.WithContext(ctx)
+	assert.Equal(t, "user-7", r2.Context().Value(key{}))
+	assert.Nil(t, r.Context().Value(key{}))
+	assert.Equal(t, r.RequestLine, r2.RequestLine)
+}
+
+func TestReceivedAt(t *testing.T) {
+	// Test: Set once the request line is in
+	before := time.Now()
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.ReceivedAt.Before(before))
+	assert.False(t, r.ReceivedAt.After(time.Now()))
+}
+
+func TestParseErrorKinds(t *testing.T) {
+	cases := []struct {
+		raw  string
+		kind error
+	}{
+		{"get / HTTP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"GET / HTTP/1.1\r\nBad Header: x\r\n\r\n", ErrMalformedHeader},
+		{"POST / HTTP/1.1\r\nContent-Length: lots\r\n\r\n", ErrBadContentLength},
+		{"GET / HTTP/1.1\r\nHost: local", ErrIncomplete},
+	}
+	for _, c := range cases {
+		_, err := RequestFromReader(strings.NewReader(c.raw))
+		assert.ErrorIs(t, err, c.kind, c.raw)
+	}
+}
@@ -0,0 +1,74 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/server"
+)
+
+// Header carries the ID in both directions.
+const Header = "X-Request-ID"
+
+// MaxLength bounds incoming IDs, which end up in every log line.
+const MaxLength = 128
+
+type contextKey struct{}
+
+// Middleware gives every request an ID, the client's X-Request-ID when it's
+// valid or a new UUIDv7 otherwise. The ID is put on the request context and
+// the response, which echoes it in X-Request-ID.
+func Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		id, ok := req.Headers.Lookup(Header)
+		if !ok || !Valid(id) {
+			id = New()
+		}
+		w.SetRequestID(id)
+		next(w, req.WithContext(NewContext(req.Context(), id)))
+	}
+}
+
+// Valid reports whether id is short enough and made of characters that are
+// safe to log and send back: letters, digits and -_.:+/=@.
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '/', c == '=', c == '@':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// New returns a UUIDv7, which sorts by creation time down to the
+// millisecond.
+func New() string {
+	var u [16]byte
+	binary.BigEndian.PutUint64(u[:8], uint64(time.Now().UnixMilli())<<16)
+	rand.Read(u[6:])
+	u[6] = u[6]&0x0f | 0x70
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the ID of the request ctx belongs to, empty without
+// one.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
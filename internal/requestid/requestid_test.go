@@ -0,0 +1,62 @@
+package requestid
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var uuidv7 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNew(t *testing.T) {
+	a, b := New(), New()
+
+	// Test: Well formed, unique and valid as incoming IDs
+	assert.Regexp(t, uuidv7, a)
+	assert.NotEqual(t, a, b)
+	assert.True(t, Valid(a))
+}
+
+func TestValid(t *testing.T) {
+	for _, id := range []string{"abc123", "req-1_2.3:4+5/6=7@host", strings.Repeat("a", MaxLength)} {
+		assert.True(t, Valid(id), id)
+	}
+	for _, id := range []string{"", strings.Repeat("a", MaxLength+1), "has space", "new\nline", `quo"te`, "ünï"} {
+		assert.False(t, Valid(id), id)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	handler := Middleware(func(w *response.Writer, req *request.Request) {
+		seen = FromContext(req.Context())
+		response.WriteError(w, response.NotFound, "")
+	})
+
+	// Test: A valid incoming ID is kept and echoed
+	req := servertest.NewRequest("GET", "/", nil)
+	req.Headers["x-request-id"] = "client-42"
+	res, err := servertest.Record(handler, req)
+	require.NoError(t, err)
+	assert.Equal(t, "client-42", seen)
+	id, _ := res.Headers.Lookup(Header)
+	assert.Equal(t, "client-42", id)
+
+	// Test: Error pages quote it
+	assert.Equal(t, "404 Not Found\nRequest ID: client-42\n", string(res.Body))
+
+	// Test: An invalid one is replaced
+	req = servertest.NewRequest("GET", "/", nil)
+	req.Headers["x-request-id"] = "bad id"
+	res, err = servertest.Record(handler, req)
+	require.NoError(t, err)
+	assert.Regexp(t, uuidv7, seen)
+	id, _ = res.Headers.Lookup(Header)
+	assert.Equal(t, seen, id)
+}
@@ -0,0 +1,153 @@
+package response
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+)
+
+// compressMinSize is the smallest declared Content-Length worth compressing,
+// below it the gzip header and trailer eat most of the savings.
+const compressMinSize = 1024
+
+var supportedCodings = []string{"gzip", "deflate"}
+
+// incompressibleTypes are already compressed, running them through gzip only
+// burns CPU.
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/octet-stream",
+	"application/pdf",
+}
+
+type compression struct {
+	// coding is the negotiated content-coding, empty when the client
+	// accepts none of ours
+	coding string
+}
+
+// EnableCompression lets the writer compress the response body with the best
+// coding from acceptEncoding. Whether it actually does is decided when the
+// headers are written, based on the status, Content-Type and Content-Length.
+func (w *Writer) EnableCompression(acceptEncoding string) {
+	w.compression = &compression{coding: negotiateEncoding(acceptEncoding)}
+}
+
+// apply rewrites the headers of a compressible response and installs the
+// body encoder. The caller's headers are left untouched.
+func (c *compression) apply(w *Writer, h headers.Headers) headers.Headers {
+	if !isCompressible(w.statusCode, h) {
+		return h
+	}
+
+	out := headers.NewHeaders()
+	for key, value := range h {
+		out[key] = value
+	}
+	vary, _ := out.Lookup("Vary")
+	out.Delete("Vary")
+	if vary == "" {
+		out.Set("Vary", "Accept-Encoding")
+	} else if !strings.Contains(strings.ToLower(vary), "accept-encoding") {
+		out.Set("Vary", vary+", Accept-Encoding")
+	} else {
+		out.Set("Vary", vary)
+	}
+
+	if c.coding == "" {
+		return out
+	}
+
+	encoder, err := newBodyEncoder(c.coding, w.framer)
+	if err != nil {
+		return h
+	}
+	w.encoder = encoder
+	out.Delete("Content-Length")
+	out.Delete("Transfer-Encoding")
+	out.Set("Content-Encoding", c.coding)
+	out.Set("Transfer-Encoding", "chunked")
+	// the ETag was made for the identity bytes, so it can only claim the
+	// compressed ones are equivalent, and byte ranges of them aren't served
+	if etag, exists := out.Lookup("ETag"); exists && !isWeakETag(etag) {
+		out.Set("ETag", "W/"+etag)
+	}
+	out.Delete("Accept-Ranges")
+	return out
+}
+
+func isCompressible(statusCode StatusCode, h headers.Headers) bool {
+	if statusCode < 200 || statusCode == 204 || statusCode == PartialContent || statusCode == NotModified {
+		return false
+	}
+	if _, exists := h.Lookup("Content-Encoding"); exists {
+		return false
+	}
+	if _, exists := h.Lookup("Content-Range"); exists {
+		return false
+	}
+	if contentLength, exists := h.Lookup("Content-Length"); exists {
+		n, err := strconv.Atoi(strings.TrimSpace(contentLength))
+		if err != nil || n < compressMinSize {
+			return false
+		}
+	}
+	contentType, exists := h.Lookup("Content-Type")
+	if !exists {
+		return false
+	}
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// negotiateEncoding picks the supported coding the client prefers, gzip on
+// ties. No Accept-Encoding at all means the client didn't ask for
+// compression, so none is used.
+func negotiateEncoding(acceptEncoding string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+	coding, err := headers.ParseAcceptEncoding(acceptEncoding).Negotiate(supportedCodings)
+	if err != nil {
+		return ""
+	}
+	return coding
+}
+
+// bodyEncoder compresses everything written to it and hands the output to
+// the framer as chunks.
+type bodyEncoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newBodyEncoder(coding string, f Framer) (bodyEncoder, error) {
+	chunked := chunkWriter{f: f}
+	switch coding {
+	case "gzip":
+		return gzip.NewWriter(chunked), nil
+	case "deflate":
+		// "deflate" in HTTP is the zlib format, not a raw deflate stream
+		return zlib.NewWriter(chunked), nil
+	}
+	return nil, fmt.Errorf("unsupported content-coding: %s", coding)
+}
@@ -0,0 +1,161 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                           "",
+		"gzip":                       "gzip",
+		"deflate":                    "deflate",
+		"gzip, deflate, br":          "gzip",
+		"deflate;q=1.0, gzip;q=0.5":  "deflate",
+		"gzip;q=0, deflate;q=0.1":    "deflate",
+		"br, *;q=0.2":                "gzip",
+		"*;q=0.3, gzip;q=0":          "deflate",
+		"identity":                   "",
+		"GZIP;Q=0.9":                 "gzip",
+		"gzip;q=oops, deflate;q=0.1": "deflate",
+	}
+	for acceptEncoding, expected := range tests {
+		assert.Equal(t, expected, negotiateEncoding(acceptEncoding), acceptEncoding)
+	}
+}
+
+// splitChunked returns the head, each of its lines ending in CRLF, the
+// de-chunked body and the trailer section of a chunked response.
+func splitChunked(t *testing.T, raw string) (string, []byte, string) {
+	head, rest, found := strings.Cut(raw, "\r\n\r\n")
+	require.True(t, found)
+	head += "\r\n"
+	r := bufio.NewReader(strings.NewReader(rest))
+	var body []byte
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			break
+		}
+		chunk := make([]byte, size+2)
+		_, err = io.ReadFull(r, chunk)
+		require.NoError(t, err)
+		body = append(body, chunk[:size]...)
+	}
+	trailers, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return head, body, string(trailers)
+}
+
+func TestCompression(t *testing.T) {
+	body := []byte(strings.Repeat("<p>Your request was an absolute banger.</p>\n", 100))
+
+	// Test: Large HTML body with WriteBody is gzipped and chunked
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.EnableCompression("gzip, deflate")
+	require.NoError(t, w.WriteStatusLine(OK))
+	h := GetDefaultHeaders(len(body))
+	h.Override("Content-Type", "text/html")
+	h.Set("ETag", `"v1"`)
+	h.Set("Accept-Ranges", "bytes")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteBody(body)
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	head, compressed, trailers := splitChunked(t, buf.String())
+	assert.Contains(t, head, "Content-Encoding: gzip\r\n")
+	assert.Contains(t, head, "Vary: Accept-Encoding\r\n")
+	assert.Contains(t, head, "Transfer-Encoding: chunked\r\n")
+	assert.NotContains(t, head, "Content-Length")
+	assert.Equal(t, "\r\n", trailers)
+	// the identity ETag is weakened and ranges aren't offered
+	assert.Contains(t, head, "ETag: W/\"v1\"\r\n")
+	assert.NotContains(t, head, "Accept-Ranges")
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, body, decoded)
+	assert.Less(t, len(compressed), len(body))
+	// the caller's headers were not modified
+	assert.Equal(t, strconv.Itoa(len(body)), h["Content-Length"])
+
+	// Test: Streaming chunked body with trailers and deflate
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.EnableCompression("deflate")
+	require.NoError(t, w.WriteStatusLine(OK))
+	h = GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	for i := 0; i < 3; i++ {
+		_, err = w.WriteChunkedBody(body)
+		require.NoError(t, err)
+	}
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{"X-Content-Length": "3"}))
+	require.NoError(t, w.Finish())
+
+	head, compressed, trailers = splitChunked(t, buf.String())
+	assert.Contains(t, head, "Content-Encoding: deflate\r\n")
+	assert.Equal(t, 1, strings.Count(strings.ToLower(head), "transfer-encoding"))
+	assert.Equal(t, "X-Content-Length: 3\r\n\r\n", trailers)
+	zr2, err := zlib.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zr2)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat(body, 3), decoded)
+
+	// Test: Tiny bodies, compressed media and partial content are left alone
+	for _, tc := range []struct {
+		statusCode  StatusCode
+		contentType string
+		body        []byte
+	}{
+		{OK, "text/html", []byte("tiny")},
+		{OK, "video/mp4", body},
+		{PartialContent, "text/html", body},
+	} {
+		buf.Reset()
+		w = NewWriter(&buf)
+		w.EnableCompression("gzip")
+		require.NoError(t, w.WriteStatusLine(tc.statusCode))
+		h = GetDefaultHeaders(len(tc.body))
+		h.Set("Content-Type", tc.contentType)
+		require.NoError(t, w.WriteHeaders(h))
+		_, err = w.WriteBody(tc.body)
+		require.NoError(t, err)
+		require.NoError(t, w.Finish())
+		assert.NotContains(t, buf.String(), "Content-Encoding", tc.contentType)
+		assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"+string(tc.body)), tc.contentType)
+	}
+
+	// Test: Client without a supported coding still gets Vary
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.EnableCompression("br")
+	require.NoError(t, w.WriteStatusLine(OK))
+	h = GetDefaultHeaders(len(body))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody(body)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "Vary: Accept-Encoding\r\n")
+	assert.NotContains(t, buf.String(), "Content-Encoding")
+}
@@ -0,0 +1,166 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+)
+
+// Validators are the representation metadata conditional requests are
+// evaluated against. Either field may be left empty.
+type Validators struct {
+	ETag         string
+	LastModified time.Time
+	// Headers of the full response, those a 304 has to repeat are taken
+	// from here.
+	Headers headers.Headers
+}
+
+// notModifiedHeaders are the ones RFC 9110 section 15.4.5 wants on a 304
+// if the 200 would have had them.
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "Expires", "Vary"}
+
+// BodyETag derives an entity-tag from the body bytes.
+func BodyETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	return formatETag(hex.EncodeToString(sum[:16]), weak)
+}
+
+// FileETag derives an entity-tag from the size and modification time of a
+// file, which avoids reading it.
+func FileETag(info fs.FileInfo, weak bool) string {
+	return formatETag(fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()), weak)
+}
+
+func formatETag(opaque string, weak bool) string {
+	if weak {
+		return `W/"` + opaque + `"`
+	}
+	return `"` + opaque + `"`
+}
+
+func SetLastModified(h headers.Headers, t time.Time) {
+	h.Set("Last-Modified", t.UTC().Format(TimeFormat))
+}
+
+// CheckPreconditions evaluates If-Match, If-Unmodified-Since, If-None-Match
+// and If-Modified-Since in the order RFC 9110 section 13.2.2 asks for. When
+// one of them fails it writes the 304 or 412 response itself and returns
+// true, in which case the handler must not write anything else.
+func CheckPreconditions(w *Writer, req *request.Request, v Validators) bool {
+	statusCode := evaluatePreconditions(req, v)
+	if statusCode == 0 {
+		return false
+	}
+
+	if statusCode == NotModified {
+		h := headers.NewHeaders()
+		h.Set("Connection", "close")
+		for _, key := range notModifiedHeaders {
+			if value, exists := v.Headers.Lookup(key); exists {
+				h.Set(key, value)
+			}
+		}
+		if v.ETag != "" {
+			h.Set("ETag", v.ETag)
+		}
+		if !v.LastModified.IsZero() {
+			SetLastModified(h, v.LastModified)
+		}
+		w.WriteStatusLine(NotModified)
+		w.WriteHeaders(h)
+		return true
+	}
+
+	WriteError(w, statusCode, "")
+	return true
+}
+
+func evaluatePreconditions(req *request.Request, v Validators) StatusCode {
+	method := req.RequestLine.Method
+	isGetOrHead := method == "GET" || method == "HEAD"
+	lastModified := v.LastModified.UTC().Truncate(time.Second)
+
+	if ifMatch, exists := req.Headers["if-match"]; exists {
+		if !etagListMatches(ifMatch, v.ETag, true) {
+			return PreconditionFailed
+		}
+	} else if ifUnmodifiedSince, exists := req.Headers["if-unmodified-since"]; exists && !v.LastModified.IsZero() {
+		t, err := time.Parse(TimeFormat, strings.TrimSpace(ifUnmodifiedSince))
+		if err == nil && lastModified.After(t) {
+			return PreconditionFailed
+		}
+	}
+
+	if ifNoneMatch, exists := req.Headers["if-none-match"]; exists {
+		if etagListMatches(ifNoneMatch, v.ETag, false) {
+			if isGetOrHead {
+				return NotModified
+			}
+			return PreconditionFailed
+		}
+	} else if ifModifiedSince, exists := req.Headers["if-modified-since"]; exists && isGetOrHead && !v.LastModified.IsZero() {
+		t, err := time.Parse(TimeFormat, strings.TrimSpace(ifModifiedSince))
+		if err == nil && !lastModified.After(t) {
+			return NotModified
+		}
+	}
+	return 0
+}
+
+// etagListMatches compares etag against a list of entity-tags or "*", using
+// the strong comparison function when strong is set and the weak one otherwise.
+func etagListMatches(list, etag string, strong bool) bool {
+	list = strings.TrimSpace(list)
+	if list == "*" {
+		return etag != ""
+	}
+	if etag == "" {
+		return false
+	}
+
+	for list != "" {
+		var candidate string
+		candidate, list = scanETag(list)
+		if candidate == "" {
+			return false
+		}
+		if strong {
+			if !isWeakETag(candidate) && !isWeakETag(etag) && candidate == etag {
+				return true
+			}
+		} else if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// scanETag splits the first entity-tag off a comma separated list. Commas are
+// allowed inside the quotes, so a plain strings.Split won't do.
+func scanETag(list string) (etag, rest string) {
+	list = strings.TrimLeft(list, " \t,")
+	start := 0
+	if strings.HasPrefix(list, "W/") {
+		start = 2
+	}
+	if len(list) < start+2 || list[start] != '"' {
+		return "", ""
+	}
+	end := strings.IndexByte(list[start+1:], '"')
+	if end == -1 {
+		return "", ""
+	}
+	end += start + 2
+	return list[:end], strings.TrimLeft(list[end:], " \t,")
+}
+
+func isWeakETag(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
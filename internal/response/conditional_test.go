@@ -0,0 +1,103 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETags(t *testing.T) {
+	// Test: Strong and weak body entity-tags
+	strong := BodyETag([]byte("hello"), false)
+	weak := BodyETag([]byte("hello"), true)
+	assert.True(t, strings.HasPrefix(strong, `"`))
+	assert.True(t, strings.HasSuffix(strong, `"`))
+	assert.Equal(t, "W/"+strong, weak)
+	assert.NotEqual(t, strong, BodyETag([]byte("hello!"), false))
+
+	// Test: Lists with commas inside the quotes
+	assert.True(t, etagListMatches(`"a,b", "c"`, `"c"`, true))
+	assert.True(t, etagListMatches(`"x", W/"c"`, `"c"`, false))
+	assert.False(t, etagListMatches(`"x", W/"c"`, `"c"`, true))
+	assert.True(t, etagListMatches(`*`, `"c"`, true))
+	assert.False(t, etagListMatches(`*`, "", true))
+	assert.False(t, etagListMatches(`garbage`, `"c"`, false))
+}
+
+func TestEvaluatePreconditions(t *testing.T) {
+	modTime := time.Date(2025, 9, 25, 10, 0, 0, 0, time.UTC)
+	v := Validators{ETag: `"v1"`, LastModified: modTime}
+	before := modTime.Add(-time.Hour).Format(TimeFormat)
+	after := modTime.Add(time.Hour).Format(TimeFormat)
+
+	// Test: No conditional headers
+	assert.Equal(t, StatusCode(0), evaluatePreconditions(newContentRequest("GET", map[string]string{}), v))
+
+	// Test: If-None-Match hit on GET and on PUT
+	assert.Equal(t, NotModified, evaluatePreconditions(newContentRequest("GET", map[string]string{"if-none-match": `W/"v1"`}), v))
+	assert.Equal(t, PreconditionFailed, evaluatePreconditions(newContentRequest("PUT", map[string]string{"if-none-match": `"v1"`}), v))
+
+	// Test: If-None-Match miss
+	assert.Equal(t, StatusCode(0), evaluatePreconditions(newContentRequest("GET", map[string]string{"if-none-match": `"v0"`}), v))
+
+	// Test: If-Match
+	assert.Equal(t, StatusCode(0), evaluatePreconditions(newContentRequest("PUT", map[string]string{"if-match": `"v1"`}), v))
+	assert.Equal(t, PreconditionFailed, evaluatePreconditions(newContentRequest("PUT", map[string]string{"if-match": `"v0"`}), v))
+	assert.Equal(t, PreconditionFailed, evaluatePreconditions(newContentRequest("PUT", map[string]string{"if-match": `W/"v1"`}), v))
+
+	// Test: If-Unmodified-Since
+	assert.Equal(t, PreconditionFailed, evaluatePreconditions(newContentRequest("PUT", map[string]string{"if-unmodified-since": before}), v))
+	assert.Equal(t, StatusCode(0), evaluatePreconditions(newContentRequest("PUT", map[string]string{"if-unmodified-since": after}), v))
+
+	// Test: If-Modified-Since
+	assert.Equal(t, NotModified, evaluatePreconditions(newContentRequest("GET", map[string]string{"if-modified-since": after}), v))
+	assert.Equal(t, StatusCode(0), evaluatePreconditions(newContentRequest("GET", map[string]string{"if-modified-since": before}), v))
+	assert.Equal(t, StatusCode(0), evaluatePreconditions(newContentRequest("POST", map[string]string{"if-modified-since": after}), v))
+
+	// Test: If-None-Match takes precedence over If-Modified-Since
+	assert.Equal(t, StatusCode(0), evaluatePreconditions(newContentRequest("GET", map[string]string{"if-none-match": `"v0"`, "if-modified-since": after}), v))
+
+	// Test: If-Match takes precedence over If-Unmodified-Since
+	assert.Equal(t, StatusCode(0), evaluatePreconditions(newContentRequest("PUT", map[string]string{"if-match": `"v1"`, "if-unmodified-since": before}), v))
+}
+
+func TestServeBytesNotModified(t *testing.T) {
+	body := []byte("<h1>hi</h1>")
+	etag := BodyETag(body, false)
+
+	// Test: Fresh request gets the ETag
+	var buf bytes.Buffer
+	err := ServeBytes(NewWriter(&buf), newContentRequest("GET", map[string]string{}), "text/html", body)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, buf.String(), "ETag: "+etag+"\r\n")
+
+	// Test: Revalidation gets a bodyless 304
+	buf.Reset()
+	err = ServeBytes(NewWriter(&buf), newContentRequest("GET", map[string]string{"if-none-match": etag}), "text/html", body)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, buf.String(), "ETag: "+etag+"\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+	assert.NotContains(t, buf.String(), "<h1>")
+
+	// Test: The 304 repeats the caching headers of the 200
+	buf.Reset()
+	err = ServeContent(NewWriter(&buf), newContentRequest("GET", map[string]string{"if-none-match": etag}), Content{
+		Body:        bytes.NewReader(body),
+		Size:        int64(len(body)),
+		ContentType: "text/html",
+		ETag:        etag,
+		Headers:     headers.Headers{"Vary": "Accept", "Cache-Control": "no-store", "X-Other": "1"},
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, buf.String(), "Vary: Accept\r\n")
+	assert.Contains(t, buf.String(), "Cache-Control: no-store\r\n")
+	assert.NotContains(t, buf.String(), "X-Other")
+}
@@ -0,0 +1,223 @@
+package response
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+)
+
+// TimeFormat is the IMF-fixdate format used by Last-Modified and friends.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Content describes a representation that can be served whole or in ranges.
+type Content struct {
+	Body        io.ReadSeeker
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	ETag        string
+	// Headers are sent along with the representation, e.g. Cache-Control.
+	Headers headers.Headers
+}
+
+var extraMimeTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mp3":  "audio/mpeg",
+	".ogg":  "audio/ogg",
+}
+
+// ServeFile serves the file at path, honouring conditional and Range requests.
+func ServeFile(w *Writer, req *request.Request, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			WriteError(w, NotFound, "")
+		} else {
+			WriteError(w, InternalServerError, "")
+		}
+		return fmt.Errorf("couldn't open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		WriteError(w, InternalServerError, "")
+		return fmt.Errorf("couldn't stat %s: %w", path, err)
+	}
+	if info.IsDir() {
+		WriteError(w, NotFound, "")
+		return fmt.Errorf("%s is a directory", path)
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	contentType := mime.TypeByExtension(ext)
+	if contentType == "" {
+		contentType = extraMimeTypes[ext]
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return ServeContent(w, req, Content{
+		Body:        f,
+		Size:        info.Size(),
+		ContentType: contentType,
+		ModTime:     info.ModTime(),
+		ETag:        FileETag(info, false),
+	})
+}
+
+// ServeBytes serves an in-memory body with a strong ETag derived from it, so
+// any handler gets 304 Not Modified and 412 Precondition Failed handling.
+func ServeBytes(w *Writer, req *request.Request, contentType string, body []byte) error {
+	return ServeContent(w, req, Content{
+		Body:        bytes.NewReader(body),
+		Size:        int64(len(body)),
+		ContentType: contentType,
+		ETag:        BodyETag(body, false),
+	})
+}
+
+// ServeContent writes c as the response to req. Preconditions are checked
+// first and may end the exchange with 304 or 412. For GET requests carrying a
+// Range header it answers with 206 Partial Content (multipart/byteranges when
+// more than one range is asked for) or 416 Range Not Satisfiable.
+func ServeContent(w *Writer, req *request.Request, c Content) error {
+	if CheckPreconditions(w, req, Validators{ETag: c.ETag, LastModified: c.ModTime, Headers: c.Headers}) {
+		return nil
+	}
+
+	h := GetDefaultHeaders(int(c.Size))
+	for key, value := range c.Headers {
+		h.Set(key, value)
+	}
+	if c.ContentType != "" {
+		h.Set("Content-Type", c.ContentType)
+	}
+	h.Set("Accept-Ranges", "bytes")
+	if !c.ModTime.IsZero() {
+		SetLastModified(h, c.ModTime)
+	}
+	if c.ETag != "" {
+		h.Set("ETag", c.ETag)
+	}
+
+	method := req.RequestLine.Method
+	rangeHeader, hasRange := req.Headers["range"]
+	if method != "GET" || !hasRange || !ifRangeMatches(req, c) {
+		return writeContent(w, method, OK, h, &rangeReader{content: c.Body, r: ByteRange{Length: c.Size}})
+	}
+
+	ranges, err := ParseRange(rangeHeader, c.Size)
+	switch {
+	case errors.Is(err, ErrUnsatisfiableRange):
+		h.Set("Content-Length", "0")
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", c.Size))
+		return writeContent(w, method, RangeNotSatisfiable, h, nil)
+	case err != nil:
+		return writeContent(w, method, OK, h, &rangeReader{content: c.Body, r: ByteRange{Length: c.Size}})
+	case len(ranges) == 1:
+		h.Set("Content-Length", fmt.Sprint(ranges[0].Length))
+		h.Set("Content-Range", ranges[0].contentRange(c.Size))
+		return writeContent(w, method, PartialContent, h, &rangeReader{content: c.Body, r: ranges[0]})
+	}
+
+	boundary, err := newBoundary()
+	if err != nil {
+		return err
+	}
+	contentType := c.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	var length int64
+	parts := make([]io.Reader, 0, len(ranges)*2+1)
+	for _, r := range ranges {
+		partHeader := fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			boundary, contentType, r.contentRange(c.Size))
+		parts = append(parts, strings.NewReader(partHeader), &rangeReader{content: c.Body, r: r})
+		length += int64(len(partHeader)) + r.Length
+	}
+	closing := fmt.Sprintf("\r\n--%s--\r\n", boundary)
+	parts = append(parts, strings.NewReader(closing))
+	length += int64(len(closing))
+
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.Set("Content-Length", fmt.Sprint(length))
+	return writeContent(w, method, PartialContent, h, io.MultiReader(parts...))
+}
+
+// ifRangeMatches reports whether a Range header may be honoured. A missing
+// If-Range always matches, an entity-tag needs a strong match and a date has
+// to be exactly the Last-Modified of the content.
+func ifRangeMatches(req *request.Request, c Content) bool {
+	ifRange, exists := req.Headers["if-range"]
+	if !exists {
+		return true
+	}
+	ifRange = strings.TrimSpace(ifRange)
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return c.ETag != "" && !strings.HasPrefix(c.ETag, "W/") && ifRange == c.ETag
+	}
+	if c.ModTime.IsZero() {
+		return false
+	}
+	t, err := time.Parse(TimeFormat, ifRange)
+	if err != nil {
+		return false
+	}
+	return c.ModTime.UTC().Truncate(time.Second).Equal(t)
+}
+
+func writeContent(w *Writer, method string, statusCode StatusCode, h headers.Headers, body io.Reader) error {
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	if method == "HEAD" || body == nil {
+		return nil
+	}
+	_, err := w.WriteBodyFrom(body)
+	return err
+}
+
+func newBoundary() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("couldn't generate multipart boundary: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// rangeReader reads a single range of the content, seeking to its start on
+// first use so several ranges of the same file can be chained lazily.
+type rangeReader struct {
+	content io.ReadSeeker
+	r       ByteRange
+	section io.Reader
+}
+
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	if rr.section == nil {
+		if _, err := rr.content.Seek(rr.r.Start, io.SeekStart); err != nil {
+			return 0, err
+		}
+		rr.section = io.LimitReader(rr.content, rr.r.Length)
+	}
+	return rr.section.Read(p)
+}
@@ -0,0 +1,143 @@
+package response
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+)
+
+// Framer puts the parts of a response on the wire for one protocol version.
+// The Writer keeps track of the order they may come in, so a Framer only
+// has to encode them.
+type Framer interface {
+	WriteHead(statusCode StatusCode, h headers.Headers) error
+	// WriteBody writes body bytes framed by Content-Length or by the end of
+	// the stream.
+	WriteBody(p []byte) (int, error)
+	// WriteChunk writes body bytes of unknown total length and EndChunks
+	// marks their end. Protocols with their own framing treat both like
+	// WriteBody.
+	WriteChunk(p []byte) (int, error)
+	EndChunks() error
+	WriteTrailers(t headers.Headers) error
+	// Flush sends whatever the Framer buffered so far.
+	Flush() error
+	// Close completes the response.
+	Close() error
+	// Abort ends the response unfinished, in a way the client notices.
+	Abort() error
+}
+
+// http1Framer writes HTTP/1.1 messages.
+type http1Framer struct {
+	w io.Writer
+	// bw is w when writes are buffered.
+	bw *bufio.Writer
+	// conn is set when w is a connection that may be hijacked, buffered
+	// holds what was read from it past the request.
+	conn     net.Conn
+	buffered []byte
+	// pendingTerminator is set once the last chunk is written but the
+	// final CRLF after the (optional) trailers is missing.
+	pendingTerminator bool
+}
+
+func (f *http1Framer) WriteHead(statusCode StatusCode, h headers.Headers) error {
+	if _, err := f.w.Write(getStatusLine(statusCode)); err != nil {
+		return err
+	}
+	return f.writeFields(h)
+}
+
+func (f *http1Framer) WriteBody(p []byte) (int, error) {
+	return f.w.Write(p)
+}
+
+func (f *http1Framer) WriteChunk(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(f.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = f.w.Write([]byte("\r\n"))
+	return n, err
+}
+
+func (f *http1Framer) EndChunks() error {
+	if _, err := f.w.Write([]byte("0\r\n")); err != nil {
+		return err
+	}
+	f.pendingTerminator = true
+	return nil
+}
+
+func (f *http1Framer) WriteTrailers(t headers.Headers) error {
+	f.pendingTerminator = false
+	return f.writeFields(t)
+}
+
+func (f *http1Framer) Flush() error {
+	if f.bw == nil {
+		return nil
+	}
+	return f.bw.Flush()
+}
+
+func (f *http1Framer) Close() error {
+	if f.pendingTerminator {
+		f.pendingTerminator = false
+		if _, err := f.w.Write([]byte("\r\n")); err != nil {
+			return err
+		}
+	}
+	return f.Flush()
+}
+
+// Abort closes the connection, the only way HTTP/1.1 has to tell a body was
+// cut short. Without a connection all it can do is leave the framing open.
+func (f *http1Framer) Abort() error {
+	f.pendingTerminator = false
+	err := f.Flush()
+	if f.conn != nil {
+		if closeErr := f.conn.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (f *http1Framer) writeFields(h headers.Headers) error {
+	for key, value := range h {
+		if _, err := fmt.Fprintf(f.w, "%s: %s\r\n", key, value); err != nil {
+			return err
+		}
+	}
+	_, err := f.w.Write([]byte("\r\n"))
+	return err
+}
+
+// chunkWriter hands everything written to it to the framer as one chunk.
+type chunkWriter struct {
+	f Framer
+}
+
+func (cw chunkWriter) Write(p []byte) (int, error) {
+	return cw.f.WriteChunk(p)
+}
+
+// bodyWriter adapts the framer's plain body writes to io.Writer.
+type bodyWriter struct {
+	f Framer
+}
+
+func (bw bodyWriter) Write(p []byte) (int, error) {
+	return bw.f.WriteBody(p)
+}
@@ -0,0 +1,76 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferedWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewBufferedWriter(&out, 4096)
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+
+	// Test: Nothing goes out until flushed
+	assert.Zero(t, out.Len())
+	require.NoError(t, w.Flush())
+	assert.Contains(t, out.String(), "5\r\nhello\r\n")
+
+	// Test: Finish flushes the rest
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, bytes.HasSuffix(out.Bytes(), []byte("5\r\nhello\r\n0\r\n\r\n")))
+}
+
+func TestWriterCounts(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+
+	// Test: Nothing before the status line
+	assert.Zero(t, w.StatusCode())
+	assert.Zero(t, w.BytesWritten())
+
+	// Test: Body bytes, headers don't count
+	body := []byte("hello world")
+	require.NoError(t, w.WriteStatusLine(NotFound))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(body))))
+	_, err := w.WriteBody(body)
+	require.NoError(t, err)
+	assert.Equal(t, NotFound, w.StatusCode())
+	assert.Equal(t, int64(len(body)), w.BytesWritten())
+}
+
+func TestAbort(t *testing.T) {
+	client, conn := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() {
+		w := NewConnWriter(conn, nil, 64)
+		h := GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(OK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("partial"))
+		w.Abort()
+		done <- w.Finish()
+	}()
+
+	// Test: What was written goes out, then the connection ends without
+	// the last chunk
+	raw, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(raw, []byte("7\r\npartial\r\n")), string(raw))
+	assert.NoError(t, <-done)
+}
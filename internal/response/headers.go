@@ -13,3 +13,45 @@ func GetDefaultHeaders(contentLen int) headers.Headers {
 	h["Content-Type"] = "text/plain"
 	return h
 }
+
+// withHeader returns a copy of h with key set to value, leaving the
+// caller's headers alone.
+func withHeader(h headers.Headers, key, value string) headers.Headers {
+	out := headers.NewHeaders()
+	for k, v := range h {
+		out[k] = v
+	}
+	out.Set(key, value)
+	return out
+}
+
+// WriteError sends a complete plain text response for statusCode. An empty
+// message falls back to the reason phrase. The request ID, if the writer has
+// one, goes on a line of its own.
+func WriteError(w *Writer, statusCode StatusCode, message string) error {
+	return WriteErrorHeaders(w, statusCode, nil, message)
+}
+
+// WriteErrorHeaders is WriteError with extra headers, such as Allow or
+// Accept-Encoding, added to the defaults.
+func WriteErrorHeaders(w *Writer, statusCode StatusCode, extra headers.Headers, message string) error {
+	if message == "" {
+		message = fmt.Sprintf("%d %s", statusCode, reasonPhrase(statusCode))
+	}
+	if w.requestID != "" {
+		message += "\nRequest ID: " + w.requestID
+	}
+	body := []byte(message + "\n")
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	h := GetDefaultHeaders(len(body))
+	for key, value := range extra {
+		h.Set(key, value)
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	_, err := w.WriteBody(body)
+	return err
+}
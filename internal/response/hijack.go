@@ -0,0 +1,50 @@
+package response
+
+import (
+	"bufio"
+	"errors"
+	"net"
+)
+
+var (
+	ErrNotHijackable = errors.New("response: connection can't be hijacked")
+	ErrHijacked      = errors.New("response: connection has been hijacked")
+)
+
+// NewConnWriter writes an HTTP/1.1 response to c and lets the handler take
+// c over with Hijack instead. buffered is what the server already read
+// from c past the end of the request. A bufSize above zero buffers writes
+// like NewBufferedWriter.
+func NewConnWriter(c net.Conn, buffered []byte, bufSize int) *Writer {
+	f := &http1Framer{w: c, conn: c, buffered: buffered}
+	if bufSize > 0 {
+		f.bw = bufio.NewWriterSize(c, bufSize)
+		f.w = f.bw
+	}
+	return NewFramedWriter(f)
+}
+
+// Hijack hands the connection to the caller, who is responsible for
+// closing it, along with bytes the client sent after the request that were
+// read from the connection already. They come before anything read from
+// the connection itself. Nothing may have been written yet, and the Writer
+// is unusable afterwards.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	f, ok := w.framer.(*http1Framer)
+	if !ok || f.conn == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	if w.writerState != writerStateStatusLine {
+		return nil, nil, errors.New("response: cannot hijack after the response started")
+	}
+	w.hijacked = true
+	return f.conn, f.buffered, nil
+}
+
+// Hijacked tells whether the connection was taken over.
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
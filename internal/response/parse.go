@@ -0,0 +1,322 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+)
+
+// Response is a parsed HTTP/1.x response, the counterpart of request.Request.
+type Response struct {
+	StatusLine  StatusLine
+	ParserState ParserState
+	Headers     headers.Headers
+	Body        []byte
+	Trailers    headers.Headers
+	// Interim holds the 1xx responses that came before the final one.
+	Interim []InterimResponse
+
+	framing        BodyFraming
+	bodyLength     int64
+	bodyLengthRead int64
+	chunkRemaining int
+}
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+type InterimResponse struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+}
+
+// "Enum" init
+type ParserState int
+
+const (
+	stateStatusLine ParserState = iota
+	stateHeaders
+	stateBody
+	stateChunkSize
+	stateChunkData
+	stateChunkEnd
+	stateTrailers
+	stateDone
+) // End of Enum init
+
+// BodyFraming is how the end of a response body is found.
+type BodyFraming int
+
+const (
+	FramingNone BodyFraming = iota
+	FramingLength
+	FramingChunked
+	FramingUntilClose
+)
+
+const crlf = "\r\n"
+const bufferSize = 8
+
+// ResponseFromReader parses a single response from reader. Without the
+// request at hand it can't know about HEAD, so only the status code decides
+// whether a body follows.
+func ResponseFromReader(reader io.Reader) (*Response, error) {
+	buff := make([]byte, bufferSize)
+	readToIndex := 0
+
+	r := Response{
+		ParserState: stateStatusLine,
+		Headers:     headers.NewHeaders(),
+		Trailers:    headers.NewHeaders(),
+		Body:        make([]byte, 0),
+	}
+
+	for r.ParserState != stateDone {
+		if readToIndex == len(buff) {
+			newBuff := make([]byte, len(buff)*2)
+			copy(newBuff, buff)
+			buff = newBuff
+		}
+
+		numOfBytesRead, err := reader.Read(buff[readToIndex:])
+		readToIndex += numOfBytesRead
+		if numOfBytesRead > 0 {
+			numOfBytesParsed, parseErr := r.parse(buff[:readToIndex])
+			if parseErr != nil {
+				return nil, fmt.Errorf("couldn't parse from buffer: %w", parseErr)
+			}
+
+			// Shifting the yet unparsed data to the beginning of the buffer.
+			if numOfBytesParsed > 0 {
+				copy(buff, buff[numOfBytesParsed:readToIndex])
+				readToIndex -= numOfBytesParsed
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				if r.ParserState == stateBody && r.framing == FramingUntilClose {
+					r.ParserState = stateDone
+					break
+				}
+				if r.ParserState != stateDone {
+					return nil, fmt.Errorf("Incomplete response, in %d, %d bytes left unparsed on EOF", r.ParserState, readToIndex)
+				}
+				break
+			}
+			return nil, err
+		}
+	}
+	return &r, nil
+}
+
+func (r *Response) parse(data []byte) (int, error) {
+	totalBytesParsed := 0
+	for r.ParserState != stateDone {
+		numOfBytesParsed, err := r.parseSingle(data[totalBytesParsed:])
+		if err != nil {
+			return 0, err
+		}
+
+		totalBytesParsed += numOfBytesParsed
+		if numOfBytesParsed == 0 {
+			break
+		}
+	}
+	return totalBytesParsed, nil
+}
+
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.ParserState {
+	case stateStatusLine:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		statusLine, err := ParseStatusLine(string(data[:idx]))
+		if err != nil {
+			return 0, err
+		}
+		r.StatusLine = *statusLine
+		r.ParserState = stateHeaders
+		return idx + len(crlf), nil
+
+	case stateHeaders:
+		numOfBytesParsed, done, err := r.Headers.Parse(data)
+		if err != nil {
+			return 0, fmt.Errorf("couldn't parse headers: %w", err)
+		}
+		if !done {
+			return numOfBytesParsed, nil
+		}
+
+		if r.StatusLine.StatusCode >= 100 && r.StatusLine.StatusCode < 200 && r.StatusLine.StatusCode != 101 {
+			// an interim response, the real one follows
+			r.Interim = append(r.Interim, InterimResponse{StatusLine: r.StatusLine, Headers: r.Headers})
+			r.StatusLine = StatusLine{}
+			r.Headers = headers.NewHeaders()
+			r.ParserState = stateStatusLine
+			return numOfBytesParsed, nil
+		}
+		if err := r.startBody(); err != nil {
+			return 0, err
+		}
+		return numOfBytesParsed, nil
+
+	case stateBody:
+		if r.framing == FramingUntilClose {
+			r.Body = append(r.Body, data...)
+			return len(data), nil
+		}
+		n := int(min(int64(len(data)), r.bodyLength-r.bodyLengthRead))
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyLengthRead += int64(n)
+		if r.bodyLengthRead == r.bodyLength {
+			r.ParserState = stateDone
+		}
+		return n, nil
+
+	case stateChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		size, err := ParseChunkSize(string(data[:idx]))
+		if err != nil {
+			return 0, err
+		}
+		r.chunkRemaining = int(size)
+		if size == 0 {
+			r.ParserState = stateTrailers
+		} else {
+			r.ParserState = stateChunkData
+		}
+		return idx + len(crlf), nil
+
+	case stateChunkData:
+		n := min(len(data), r.chunkRemaining)
+		r.Body = append(r.Body, data[:n]...)
+		r.chunkRemaining -= n
+		if r.chunkRemaining == 0 {
+			r.ParserState = stateChunkEnd
+		}
+		return n, nil
+
+	case stateChunkEnd:
+		if len(data) < len(crlf) {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, fmt.Errorf("missing CRLF after chunk data")
+		}
+		r.ParserState = stateChunkSize
+		return len(crlf), nil
+
+	case stateTrailers:
+		numOfBytesParsed, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, fmt.Errorf("couldn't parse trailers: %w", err)
+		}
+		if done {
+			r.ParserState = stateDone
+		}
+		return numOfBytesParsed, nil
+
+	case stateDone:
+		return 0, fmt.Errorf("Trying to read data in Done state")
+	default:
+		return 0, fmt.Errorf("Unknown state")
+	}
+}
+
+// startBody works out how the body is framed once the headers are in.
+func (r *Response) startBody() error {
+	framing, length, err := Framing(r.StatusLine.StatusCode, r.Headers)
+	if err != nil {
+		return err
+	}
+	r.framing = framing
+	r.bodyLength = length
+	switch {
+	case framing == FramingNone || (framing == FramingLength && length == 0):
+		r.ParserState = stateDone
+	case framing == FramingChunked:
+		r.ParserState = stateChunkSize
+	default:
+		r.ParserState = stateBody
+	}
+	return nil
+}
+
+// Framing works out from the status code and headers how the body after a
+// response head is delimited, and for FramingLength how long it is. A
+// response to HEAD never has a body, which only the caller knows about.
+func Framing(statusCode StatusCode, h headers.Headers) (BodyFraming, int64, error) {
+	if statusCode < 200 || statusCode == 204 || statusCode == NotModified {
+		return FramingNone, 0, nil
+	}
+
+	if transferEncoding, exists := h.Get("Transfer-Encoding"); exists {
+		codings := strings.Split(transferEncoding, ",")
+		if strings.TrimSpace(codings[len(codings)-1]) == "chunked" {
+			return FramingChunked, 0, nil
+		}
+		// no way to tell where the body ends but the connection closing
+		return FramingUntilClose, 0, nil
+	}
+
+	if contentLength, exists := h.Get("Content-Length"); exists {
+		n, err := strconv.ParseInt(strings.TrimSpace(contentLength), 10, 64)
+		if err != nil || n < 0 {
+			return FramingNone, 0, fmt.Errorf("malformed Content-Length: %q", contentLength)
+		}
+		return FramingLength, n, nil
+	}
+
+	return FramingUntilClose, 0, nil
+}
+
+// ParseChunkSize parses the hex size at the start of a chunk, ignoring any
+// chunk extensions after a semicolon.
+func ParseChunkSize(line string) (int64, error) {
+	sizeStr, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("malformed chunk size: %q", line)
+	}
+	return size, nil
+}
+
+// ParseStatusLine parses "HTTP/1.1 200 OK". The reason phrase may be empty.
+func ParseStatusLine(line string) (*StatusLine, error) {
+	httpVersion, rest, found := strings.Cut(line, " ")
+	if !found {
+		return nil, fmt.Errorf("status-line missing parts: %q", line)
+	}
+	httpVersionParts := strings.Split(httpVersion, "/")
+	if len(httpVersionParts) != 2 || httpVersionParts[0] != "HTTP" {
+		return nil, fmt.Errorf("malformed HTTP version: %s", httpVersion)
+	}
+	if httpVersionParts[1] != "1.1" && httpVersionParts[1] != "1.0" {
+		return nil, fmt.Errorf("unsupported HTTP version: %s", httpVersionParts[1])
+	}
+
+	code, reasonPhrase, _ := strings.Cut(rest, " ")
+	statusCode, err := strconv.Atoi(code)
+	if len(code) != 3 || err != nil || statusCode < 100 {
+		return nil, fmt.Errorf("malformed status code: %q", code)
+	}
+	return &StatusLine{
+		HttpVersion:  httpVersionParts[1],
+		StatusCode:   StatusCode(statusCode),
+		ReasonPhrase: reasonPhrase,
+	}, nil
+}
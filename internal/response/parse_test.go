@@ -0,0 +1,181 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
+
+func TestParseStatusLine(t *testing.T) {
+	// Test: Good status line
+	statusLine, err := ParseStatusLine("HTTP/1.1 404 Not Found")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", statusLine.HttpVersion)
+	assert.Equal(t, NotFound, statusLine.StatusCode)
+	assert.Equal(t, "Not Found", statusLine.ReasonPhrase)
+
+	// Test: Empty reason phrase
+	statusLine, err = ParseStatusLine("HTTP/1.0 299 ")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(299), statusLine.StatusCode)
+	assert.Equal(t, "", statusLine.ReasonPhrase)
+
+	// Test: Malformed status lines
+	for _, line := range []string{"HTTP/1.1", "HTTP/2 200 OK", "HTTP1.1 200 OK", "HTTP/1.1 20 OK", "HTTP/1.1 abc OK", "HTTP/1.1 099 Low"} {
+		_, err = ParseStatusLine(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestFraming(t *testing.T) {
+	tests := []struct {
+		statusCode StatusCode
+		h          headers.Headers
+		framing    BodyFraming
+		length     int64
+	}{
+		{OK, headers.Headers{"content-length": "12"}, FramingLength, 12},
+		{OK, headers.Headers{"transfer-encoding": "gzip, chunked", "content-length": "12"}, FramingChunked, 0},
+		{OK, headers.Headers{"transfer-encoding": "gzip"}, FramingUntilClose, 0},
+		{OK, headers.Headers{}, FramingUntilClose, 0},
+		{NotModified, headers.Headers{"content-length": "12"}, FramingNone, 0},
+		{204, headers.Headers{}, FramingNone, 0},
+	}
+	for _, tc := range tests {
+		framing, length, err := Framing(tc.statusCode, tc.h)
+		require.NoError(t, err, tc.h)
+		assert.Equal(t, tc.framing, framing, tc.h)
+		assert.Equal(t, tc.length, length, tc.h)
+	}
+
+	// Test: Malformed lengths
+	_, _, err := Framing(OK, headers.Headers{"content-length": "-1"})
+	assert.Error(t, err)
+	_, err = ParseChunkSize("zz")
+	assert.Error(t, err)
+	size, err := ParseChunkSize("1a;name=value")
+	require.NoError(t, err)
+	assert.Equal(t, int64(26), size)
+}
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Content-Length body, read 3 bytes at a time
+	r, err := ResponseFromReader(&chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, OK, r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "text/plain", r.Headers["content-type"])
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: Chunked body with trailers, read 1 byte at a time
+	r, err = ResponseFromReader(&chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Content-Length\r\n\r\n" +
+			"5\r\nhello\r\n7;name=value\r\n, world\r\n0\r\nX-Content-Length: 12\r\n\r\n",
+		numBytesPerRead: 1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(r.Body))
+	assert.Equal(t, "12", r.Trailers["x-content-length"])
+
+	// Test: Interim responses before the final one
+	r, err = ResponseFromReader(&chunkReader{
+		data: "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" +
+			"HTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok",
+		numBytesPerRead: 4,
+	})
+	require.NoError(t, err)
+	require.Len(t, r.Interim, 2)
+	assert.Equal(t, StatusCode(100), r.Interim[0].StatusLine.StatusCode)
+	assert.Equal(t, StatusCode(103), r.Interim[1].StatusLine.StatusCode)
+	assert.Equal(t, "</style.css>; rel=preload", r.Interim[1].Headers["link"])
+	assert.Equal(t, StatusCode(201), r.StatusLine.StatusCode)
+	assert.Equal(t, "ok", string(r.Body))
+
+	// Test: Body delimited by the end of the stream
+	r, err = ResponseFromReader(&chunkReader{
+		data:            "HTTP/1.0 200 OK\r\n\r\nall of it",
+		numBytesPerRead: 2,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "all of it", string(r.Body))
+
+	// Test: Bodyless statuses stop at the headers
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 304 Not Modified\r\nETag: \"v1\"\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+
+	// Test: Body shorter than Content-Length
+	_, err = ResponseFromReader(&chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 20\r\n\r\npartial",
+		numBytesPerRead: 3,
+	})
+	require.Error(t, err)
+
+	// Test: Truncated chunked body
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel"))
+	require.Error(t, err)
+
+	// Test: Garbage chunk size
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"))
+	require.Error(t, err)
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	// Test: Whatever the writer produces, the parser reads back byte-for-byte
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(BadRequest))
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBody([]byte("first "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("second"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{"X-Checksum": "abc"}))
+	raw := buf.String()
+
+	r, err := ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, BadRequest, r.StatusLine.StatusCode)
+	assert.Equal(t, "Bad Request", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "close", r.Headers["connection"])
+	assert.Equal(t, "first second", string(r.Body))
+	assert.Equal(t, "abc", r.Trailers["x-checksum"])
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.True(t, strings.HasSuffix(raw, "6\r\nfirst \r\n6\r\nsecond\r\n0\r\nX-Checksum: abc\r\n\r\n"))
+}
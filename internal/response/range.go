@@ -0,0 +1,125 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteRange is a single resolved range of a representation, already clamped
+// to its size.
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+var (
+	ErrInvalidRange       = errors.New("invalid range")
+	ErrUnsatisfiableRange = errors.New("range not satisfiable")
+)
+
+const maxRanges = 100
+
+func (r ByteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange parses a Range header value against a representation of the
+// given size. ErrInvalidRange means the header should be ignored and the full
+// content served, ErrUnsatisfiableRange means a 416 should be sent.
+func ParseRange(s string, size int64) ([]ByteRange, error) {
+	unit, spec, found := strings.Cut(strings.TrimSpace(s), "=")
+	if !found || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, ErrInvalidRange
+	}
+
+	specs := strings.Split(spec, ",")
+	if len(specs) > maxRanges {
+		return nil, ErrInvalidRange
+	}
+
+	ranges := make([]ByteRange, 0, len(specs))
+	var total int64
+	for _, rangeSpec := range specs {
+		rangeSpec = strings.TrimSpace(rangeSpec)
+		if rangeSpec == "" {
+			continue
+		}
+		first, last, found := strings.Cut(rangeSpec, "-")
+		if !found {
+			return nil, ErrInvalidRange
+		}
+		first = strings.TrimSpace(first)
+		last = strings.TrimSpace(last)
+
+		var r ByteRange
+		if first == "" {
+			// suffix-range: the last N bytes of the representation
+			suffix, err := parseRangeInt(last)
+			if err != nil {
+				return nil, err
+			}
+			if suffix == 0 {
+				continue
+			}
+			if suffix > size {
+				suffix = size
+			}
+			r = ByteRange{Start: size - suffix, Length: suffix}
+		} else {
+			start, err := parseRangeInt(first)
+			if err != nil {
+				return nil, err
+			}
+			end := size - 1
+			if last != "" {
+				end, err = parseRangeInt(last)
+				if err != nil {
+					return nil, err
+				}
+				if end < start {
+					return nil, ErrInvalidRange
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = ByteRange{Start: start, Length: end - start + 1}
+		}
+		if r.Length <= 0 {
+			continue
+		}
+		ranges = append(ranges, r)
+		total += r.Length
+	}
+
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiableRange
+	}
+	// Asking for more bytes than the whole representation holds is either
+	// a broken or a hostile client, just send everything once.
+	if total > size {
+		return nil, ErrInvalidRange
+	}
+	return ranges, nil
+}
+
+func parseRangeInt(s string) (int64, error) {
+	if s == "" {
+		return 0, ErrInvalidRange
+	}
+	for _, char := range s {
+		if char < '0' || char > '9' {
+			return 0, ErrInvalidRange
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidRange
+	}
+	return n, nil
+}
@@ -0,0 +1,149 @@
+package response
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	// Test: Single range
+	ranges, err := ParseRange("bytes=0-4", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 5}}, ranges)
+
+	// Test: Open ended range
+	ranges, err = ParseRange("bytes=7-", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 7, Length: 3}}, ranges)
+
+	// Test: Suffix range
+	ranges, err = ParseRange("bytes=-3", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 7, Length: 3}}, ranges)
+
+	// Test: Suffix range longer than content
+	ranges, err = ParseRange("bytes=-30", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 10}}, ranges)
+
+	// Test: Last position past the end is clamped
+	ranges, err = ParseRange("bytes=5-100", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 5, Length: 5}}, ranges)
+
+	// Test: Multiple ranges with whitespace
+	ranges, err = ParseRange("bytes=0-1, 4-5 ,-2", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 2}, {Start: 4, Length: 2}, {Start: 8, Length: 2}}, ranges)
+
+	// Test: Unsatisfiable ranges are dropped
+	ranges, err = ParseRange("bytes=20-30, 0-0", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 1}}, ranges)
+
+	// Test: Nothing satisfiable
+	_, err = ParseRange("bytes=20-30", 10)
+	assert.ErrorIs(t, err, ErrUnsatisfiableRange)
+	_, err = ParseRange("bytes=-0", 10)
+	assert.ErrorIs(t, err, ErrUnsatisfiableRange)
+
+	// Test: Invalid ranges
+	for _, s := range []string{"bytes=5-2", "items=0-1", "bytes=a-b", "bytes=1", "bytes=-", "0-4", "bytes=+1-2"} {
+		_, err = ParseRange(s, 10)
+		assert.ErrorIs(t, err, ErrInvalidRange, s)
+	}
+
+	// Test: Overlapping ranges asking for more than the whole content
+	_, err = ParseRange("bytes=0-9,0-9", 10)
+	assert.ErrorIs(t, err, ErrInvalidRange)
+}
+
+func newContentRequest(method string, h map[string]string) *request.Request {
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: "/video", HttpVersion: "1.1"},
+		Headers:     headers.Headers(h),
+	}
+}
+
+func TestServeContent(t *testing.T) {
+	modTime := time.Date(2025, 9, 25, 10, 0, 0, 0, time.UTC)
+	newContent := func() Content {
+		return Content{
+			Body:        strings.NewReader("0123456789"),
+			Size:        10,
+			ContentType: "text/plain",
+			ModTime:     modTime,
+			ETag:        `"v1"`,
+		}
+	}
+
+	// Test: No range serves everything
+	var buf bytes.Buffer
+	err := ServeContent(NewWriter(&buf), newContentRequest("GET", map[string]string{}), newContent())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, buf.String(), "Accept-Ranges: bytes\r\n")
+	assert.Contains(t, buf.String(), "Last-Modified: Thu, 25 Sep 2025 10:00:00 GMT\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n0123456789"))
+
+	// Test: Single range
+	buf.Reset()
+	err = ServeContent(NewWriter(&buf), newContentRequest("GET", map[string]string{"range": "bytes=2-4"}), newContent())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, buf.String(), "Content-Range: bytes 2-4/10\r\n")
+	assert.Contains(t, buf.String(), "Content-Length: 3\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n234"))
+
+	// Test: Multiple ranges
+	buf.Reset()
+	err = ServeContent(NewWriter(&buf), newContentRequest("GET", map[string]string{"range": "bytes=0-1,-2"}), newContent())
+	require.NoError(t, err)
+	head, body, found := strings.Cut(buf.String(), "\r\n\r\n")
+	require.True(t, found)
+	assert.Contains(t, head, "Content-Type: multipart/byteranges; boundary=")
+	assert.Contains(t, head, "Content-Length: "+strconv.Itoa(len(body)))
+	assert.Contains(t, body, "Content-Range: bytes 0-1/10\r\n\r\n01\r\n")
+	assert.Contains(t, body, "Content-Range: bytes 8-9/10\r\n\r\n89\r\n")
+	assert.True(t, strings.HasSuffix(body, "--\r\n"))
+
+	// Test: Unsatisfiable range
+	buf.Reset()
+	err = ServeContent(NewWriter(&buf), newContentRequest("GET", map[string]string{"range": "bytes=50-"}), newContent())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Contains(t, buf.String(), "Content-Range: bytes */10\r\n")
+
+	// Test: Matching If-Range entity-tag
+	buf.Reset()
+	err = ServeContent(NewWriter(&buf), newContentRequest("GET", map[string]string{"range": "bytes=0-0", "if-range": `"v1"`}), newContent())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 206 Partial Content\r\n"))
+
+	// Test: Stale If-Range entity-tag sends the full content
+	buf.Reset()
+	err = ServeContent(NewWriter(&buf), newContentRequest("GET", map[string]string{"range": "bytes=0-0", "if-range": `"v0"`}), newContent())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Matching If-Range date
+	buf.Reset()
+	err = ServeContent(NewWriter(&buf), newContentRequest("GET", map[string]string{"range": "bytes=0-0", "if-range": "Thu, 25 Sep 2025 10:00:00 GMT"}), newContent())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 206 Partial Content\r\n"))
+
+	// Test: Range is ignored for HEAD, and no body is sent
+	buf.Reset()
+	err = ServeContent(NewWriter(&buf), newContentRequest("HEAD", map[string]string{"range": "bytes=0-0"}), newContent())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+}
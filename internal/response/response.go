@@ -1,6 +1,7 @@
 package response
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 
@@ -17,25 +18,56 @@ const (
 )
 
 type Writer struct {
-	writerState writerState
-	writer      io.Writer
+	writerState  writerState
+	framer       Framer
+	statusCode   StatusCode
+	bytesWritten int64
+
+	// compression is set by EnableCompression, encoder once WriteHeaders
+	// decided the response is worth compressing.
+	compression *compression
+	encoder     bodyEncoder
+
+	hijacked bool
+	aborted  bool
+
+	// requestID is sent in X-Request-ID and quoted on error pages
+	requestID string
 }
 
+// NewWriter writes an HTTP/1.1 response to w.
 func NewWriter(w io.Writer) *Writer {
+	return NewFramedWriter(&http1Framer{w: w})
+}
+
+// NewBufferedWriter writes an HTTP/1.1 response to w through a buffer of
+// size bytes. Flush and Finish send what's buffered.
+func NewBufferedWriter(w io.Writer, size int) *Writer {
+	bw := bufio.NewWriterSize(w, size)
+	return NewFramedWriter(&http1Framer{w: bw, bw: bw})
+}
+
+// NewFramedWriter writes a response in whatever protocol f speaks.
+func NewFramedWriter(f Framer) *Writer {
 	return &Writer{
-		writer:      w,
+		framer:      f,
 		writerState: writerStateStatusLine,
 	}
 }
 
+// WriteStatusLine records the status. It's sent along with the headers,
+// which every response has.
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	if w.hijacked {
+		return ErrHijacked
+	}
 	if w.writerState != writerStateStatusLine {
 		return fmt.Errorf("cannot write status line in state: %d", w.writerState)
 	}
 
-	defer func() { w.writerState = writerStateHeaders }()
-	_, err := w.writer.Write(getStatusLine(statusCode))
-	return err
+	w.writerState = writerStateHeaders
+	w.statusCode = statusCode
+	return nil
 }
 
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
@@ -44,36 +76,56 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	}
 
 	defer func() { w.writerState = writerStateBody }()
-	for key, value := range headers {
-		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, value)))
-		if err != nil {
-			return nil
-		}
+	if w.requestID != "" {
+		headers = withHeader(headers, "X-Request-ID", w.requestID)
+	}
+	if w.compression != nil {
+		headers = w.compression.apply(w, headers)
 	}
-	_, err := w.writer.Write([]byte("\r\n"))
-	return err
+	return w.framer.WriteHead(w.statusCode, headers)
 }
 
 func (w *Writer) WriteTrailers(t headers.Headers) error {
 	if w.writerState != writerStateTrailers {
 		return fmt.Errorf("cannot write trailers in state: %d", w.writerState)
 	}
-	for key, value := range t {
-		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, value)))
-		if err != nil {
-			return nil
+	return w.framer.WriteTrailers(t)
+}
+
+func (w *Writer) WriteBody(p []byte) (int, error) {
+	if w.writerState != writerStateBody {
+		return 0, fmt.Errorf("cannot write body in current state: %d", w.writerState)
+	}
+	defer func() { w.writerState = writerStateTrailers }()
+	if w.encoder != nil {
+		if _, err := w.encoder.Write(p); err != nil {
+			return 0, err
 		}
+		w.bytesWritten += int64(len(p))
+		return len(p), w.endEncodedBody()
 	}
-	_, err := w.writer.Write([]byte("\r\n"))
-	return err
+	n, err := w.framer.WriteBody(p)
+	w.bytesWritten += int64(n)
+	return n, err
 }
 
-func (w *Writer) WriteBody(p []byte) (int, error) {
+// WriteBodyFrom streams r into the body instead of requiring it in memory.
+func (w *Writer) WriteBodyFrom(r io.Reader) (int64, error) {
 	if w.writerState != writerStateBody {
 		return 0, fmt.Errorf("cannot write body in current state: %d", w.writerState)
 	}
 	defer func() { w.writerState = writerStateTrailers }()
-	return w.writer.Write(p)
+	if w.encoder != nil {
+		n, err := io.Copy(w.encoder, r)
+		w.bytesWritten += n
+		if err != nil {
+			return n, err
+		}
+		return n, w.endEncodedBody()
+	}
+	n, err := io.Copy(bodyWriter{f: w.framer}, r)
+	w.bytesWritten += n
+	return n, err
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
@@ -81,22 +133,103 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 		return 0, fmt.Errorf("cannot write body in current state: %d", w.writerState)
 	}
 
-	if len(p) > 0 {
-		n, err := w.writer.Write([]byte(
-			fmt.Sprintf("%x\r\n%s\r\n", len(p), p)))
-		if err != nil {
+	if w.encoder != nil {
+		// flushing every chunk keeps streamed responses streaming, at
+		// the cost of a slightly worse compression ratio
+		if _, err := w.encoder.Write(p); err != nil {
+			return 0, err
+		}
+		if err := w.encoder.Flush(); err != nil {
 			return 0, err
 		}
-		return n, nil
+		w.bytesWritten += int64(len(p))
+		return len(p), nil
 	}
-	return 0, nil
+	n, err := w.framer.WriteChunk(p)
+	w.bytesWritten += int64(n)
+	return n, err
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	defer func() { w.writerState = writerStateTrailers }()
-	n, err := w.writer.Write([]byte("0\r\n"))
-	if err != nil {
-		return 0, err
+	if w.encoder != nil {
+		if err := w.encoder.Close(); err != nil {
+			return 0, err
+		}
+	}
+	return 0, w.framer.EndChunks()
+}
+
+// SetRequestID tags the response with id, which is added to the headers and
+// to error pages so clients can quote it. It has to be set before
+// WriteHeaders.
+func (w *Writer) SetRequestID(id string) {
+	w.requestID = id
+}
+
+// RequestID is the ID given to SetRequestID, empty without one.
+func (w *Writer) RequestID() string {
+	return w.requestID
+}
+
+// StatusCode is the status the handler wrote, 0 before WriteStatusLine.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten counts the body bytes the handler wrote, before any
+// compression.
+func (w *Writer) BytesWritten() int64 {
+	return w.bytesWritten
+}
+
+// Flush sends the body written so far to the client, including what a
+// compressor held back.
+func (w *Writer) Flush() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	if w.encoder != nil && w.writerState == writerStateBody {
+		if err := w.encoder.Flush(); err != nil {
+			return err
+		}
+	}
+	return w.framer.Flush()
+}
+
+// Finish completes whatever framing the handler left open. The server calls
+// it once the handler has returned.
+func (w *Writer) Finish() error {
+	if w.hijacked || w.aborted {
+		return nil
+	}
+	if w.encoder != nil && w.writerState == writerStateBody {
+		w.writerState = writerStateTrailers
+		if err := w.endEncodedBody(); err != nil {
+			return err
+		}
+	}
+	return w.framer.Close()
+}
+
+// Abort gives up on a response that can't be completed, such as one whose
+// body failed halfway. The client sees it end early instead of a shorter
+// but well-formed response: the HTTP/1.1 connection is closed, an HTTP/2
+// stream reset. Nothing may be written afterwards.
+func (w *Writer) Abort() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	if w.aborted {
+		return nil
+	}
+	w.aborted = true
+	return w.framer.Abort()
+}
+
+func (w *Writer) endEncodedBody() error {
+	if err := w.encoder.Close(); err != nil {
+		return err
 	}
-	return n, nil
+	return w.framer.EndChunks()
 }
@@ -5,20 +5,78 @@ import "fmt"
 type StatusCode int
 
 const (
+	SwitchingProtocols  StatusCode = 101
 	OK                  StatusCode = 200
+	Accepted            StatusCode = 202
+	PartialContent      StatusCode = 206
+	NotModified         StatusCode = 304
 	BadRequest          StatusCode = 400
+	Unauthorized        StatusCode = 401
+	Forbidden           StatusCode = 403
+	NotFound            StatusCode = 404
+	MethodNotAllowed    StatusCode = 405
+	NotAcceptable       StatusCode = 406
+	Conflict            StatusCode = 409
+	PreconditionFailed  StatusCode = 412
+	ContentTooLarge     StatusCode = 413
+	UnsupportedMedia    StatusCode = 415
+	RangeNotSatisfiable StatusCode = 416
+	UpgradeRequired     StatusCode = 426
 	InternalServerError StatusCode = 500
+	BadGateway          StatusCode = 502
+	ServiceUnavailable  StatusCode = 503
+	GatewayTimeout      StatusCode = 504
 )
 
 func getStatusLine(statusCode StatusCode) []byte {
+	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reasonPhrase(statusCode)))
+}
+
+func reasonPhrase(statusCode StatusCode) string {
 	reasonPhrase := ""
 	switch statusCode {
+	case SwitchingProtocols:
+		reasonPhrase = "Switching Protocols"
 	case OK:
 		reasonPhrase = "OK"
+	case Accepted:
+		reasonPhrase = "Accepted"
+	case PartialContent:
+		reasonPhrase = "Partial Content"
+	case NotModified:
+		reasonPhrase = "Not Modified"
 	case BadRequest:
 		reasonPhrase = "Bad Request"
+	case Unauthorized:
+		reasonPhrase = "Unauthorized"
+	case Forbidden:
+		reasonPhrase = "Forbidden"
+	case NotFound:
+		reasonPhrase = "Not Found"
+	case MethodNotAllowed:
+		reasonPhrase = "Method Not Allowed"
+	case NotAcceptable:
+		reasonPhrase = "Not Acceptable"
+	case Conflict:
+		reasonPhrase = "Conflict"
+	case PreconditionFailed:
+		reasonPhrase = "Precondition Failed"
+	case ContentTooLarge:
+		reasonPhrase = "Content Too Large"
+	case UnsupportedMedia:
+		reasonPhrase = "Unsupported Media Type"
+	case RangeNotSatisfiable:
+		reasonPhrase = "Range Not Satisfiable"
+	case UpgradeRequired:
+		reasonPhrase = "Upgrade Required"
 	case InternalServerError:
 		reasonPhrase = "Internal Server Error"
+	case BadGateway:
+		reasonPhrase = "Bad Gateway"
+	case ServiceUnavailable:
+		reasonPhrase = "Service Unavailable"
+	case GatewayTimeout:
+		reasonPhrase = "Gateway Timeout"
 	}
-	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reasonPhrase))
+	return reasonPhrase
 }
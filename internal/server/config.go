@@ -0,0 +1,35 @@
+package server
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// Config describes how the server was set up.
+type Config struct {
+	Network string
+	Addr    string
+	TLS     bool
+	// MinTLSVersion and ClientAuth are empty without TLS.
+	MinTLSVersion  string
+	ClientAuth     string
+	H2C            bool
+	WriteBuffer    int
+	RequestTimeout time.Duration
+}
+
+func (s *Server) Config() Config {
+	c := Config{
+		Network:        s.listener.Addr().Network(),
+		Addr:           s.listener.Addr().String(),
+		H2C:            s.h2c,
+		WriteBuffer:    s.writeBuf,
+		RequestTimeout: s.reqTimeout,
+	}
+	if s.tlsConf != nil {
+		c.TLS = true
+		c.MinTLSVersion = tls.VersionName(s.tlsConf.minVersion)
+		c.ClientAuth = s.tlsConf.clientAuth.String()
+	}
+	return c
+}
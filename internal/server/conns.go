@@ -0,0 +1,172 @@
+package server
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+)
+
+// ConnState is where a connection is in its life.
+type ConnState string
+
+const (
+	// StateNew connections haven't carried a request yet.
+	StateNew ConnState = "new"
+	// StateActive connections have at least one handler running.
+	StateActive ConnState = "active"
+	// StateIdle connections are between requests.
+	StateIdle ConnState = "idle"
+)
+
+// ConnInfo is a snapshot of an open connection.
+type ConnInfo struct {
+	ID         uint64
+	RemoteAddr string
+	LocalAddr  string
+	// Proto is the protocol of the last request, or HTTP/2 as soon as the
+	// client sent the preface. Empty before the first request.
+	Proto    string
+	State    ConnState
+	Opened   time.Time
+	BytesIn  uint64
+	BytesOut uint64
+	// Requests are the ones in flight, oldest first. HTTP/2 connections
+	// can have several.
+	Requests []RequestInfo
+}
+
+type RequestInfo struct {
+	Seq     uint64
+	Method  string
+	Target  string
+	Started time.Time
+}
+
+// trackedConn is the server's record of a connection while it's open.
+type trackedConn struct {
+	id     uint64
+	conn   net.Conn
+	opened time.Time
+
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+
+	mu       sync.Mutex
+	proto    string
+	served   uint64
+	idleAt   time.Time
+	requests map[uint64]RequestInfo
+}
+
+func (s *Server) track(c net.Conn, id uint64) *trackedConn {
+	tc := &trackedConn{id: id, conn: c, opened: time.Now(), requests: map[uint64]RequestInfo{}}
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if s.conns == nil {
+		s.conns = map[uint64]*trackedConn{}
+	}
+	s.conns[id] = tc
+	return tc
+}
+
+func (s *Server) untrack(tc *trackedConn) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	delete(s.conns, tc.id)
+}
+
+// Conns lists the open connections by ID. Connections taken over with
+// Hijack are listed until their handler returns.
+func (s *Server) Conns() []ConnInfo {
+	s.connsMu.Lock()
+	conns := make([]*trackedConn, 0, len(s.conns))
+	for _, tc := range s.conns {
+		conns = append(conns, tc)
+	}
+	s.connsMu.Unlock()
+
+	infos := make([]ConnInfo, 0, len(conns))
+	for _, tc := range conns {
+		infos = append(infos, tc.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+func (tc *trackedConn) info() ConnInfo {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	info := ConnInfo{
+		ID:         tc.id,
+		RemoteAddr: tc.conn.RemoteAddr().String(),
+		LocalAddr:  tc.conn.LocalAddr().String(),
+		Proto:      tc.proto,
+		State:      tc.state(),
+		Opened:     tc.opened,
+		BytesIn:    tc.bytesIn.Load(),
+		BytesOut:   tc.bytesOut.Load(),
+	}
+	for _, r := range tc.requests {
+		info.Requests = append(info.Requests, r)
+	}
+	sort.Slice(info.Requests, func(i, j int) bool { return info.Requests[i].Seq < info.Requests[j].Seq })
+	return info
+}
+
+// state must be called with tc.mu held.
+func (tc *trackedConn) state() ConnState {
+	switch {
+	case len(tc.requests) > 0:
+		return StateActive
+	case tc.served > 0:
+		return StateIdle
+	}
+	return StateNew
+}
+
+func (tc *trackedConn) setProto(proto string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.proto = proto
+}
+
+func (tc *trackedConn) startRequest(req *request.Request) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.proto = "HTTP/" + req.RequestLine.HttpVersion
+	tc.requests[req.Seq] = RequestInfo{
+		Seq:     req.Seq,
+		Method:  req.RequestLine.Method,
+		Target:  req.RequestLine.RequestTarget,
+		Started: time.Now(),
+	}
+}
+
+func (tc *trackedConn) endRequest(seq uint64) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	delete(tc.requests, seq)
+	tc.served++
+	tc.idleAt = time.Now()
+}
+
+// drainable tells whether Shutdown may close the connection without cutting
+// a request or response short. New connections get drainGrace to send
+// their request. HTTP/1 connections close themselves after one request, and
+// an HTTP/2 stream still sends its last frame after the handler returned,
+// so idle HTTP/2 connections get drainGrace too.
+func (tc *trackedConn) drainable() bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	switch tc.state() {
+	case StateNew:
+		return time.Since(tc.opened) >= drainGrace
+	case StateIdle:
+		return tc.proto == "HTTP/2" && time.Since(tc.idleAt) >= drainGrace
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"context"
+	"net"
+)
+
+type contextKey int
+
+const (
+	localAddrKey contextKey = iota
+	remoteAddrKey
+)
+
+// LocalAddr is the address of the connection a request arrived on, taken
+// from the request's context.
+func LocalAddr(ctx context.Context) (net.Addr, bool) {
+	addr, ok := ctx.Value(localAddrKey).(net.Addr)
+	return addr, ok
+}
+
+// RemoteAddr is the address of the client that sent a request, taken from
+// the request's context.
+func RemoteAddr(ctx context.Context) (net.Addr, bool) {
+	addr, ok := ctx.Value(remoteAddrKey).(net.Addr)
+	return addr, ok
+}
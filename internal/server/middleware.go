@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+)
+
+type Middleware func(Handler) Handler
+
+// Chain wraps h in the middleware, the first one ending up outermost.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Compress negotiates gzip or deflate from Accept-Encoding for every
+// response the wrapped handler writes.
+func Compress(next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method != "HEAD" {
+			acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+			w.EnableCompression(acceptEncoding)
+		}
+		next(w, req)
+	}
+}
+
+// DecodeRequestBody transparently decodes gzip and deflate request bodies
+// before the wrapped handler sees them, refusing to inflate past maxSize.
+func DecodeRequestBody(maxSize int64) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			err := req.DecodeBody(maxSize)
+			switch {
+			case errors.Is(err, request.ErrUnsupportedEncoding):
+				// tell the client which codings it can use instead
+				extra := headers.Headers{"Accept-Encoding": "gzip, deflate"}
+				response.WriteErrorHeaders(w, response.UnsupportedMedia, extra, err.Error())
+				return
+			case errors.Is(err, request.ErrBodyTooLarge):
+				response.WriteError(w, response.ContentTooLarge, err.Error())
+				return
+			case err != nil:
+				response.WriteError(w, response.BadRequest, err.Error())
+				return
+			}
+			next(w, req)
+		}
+	}
+}
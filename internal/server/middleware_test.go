@@ -0,0 +1,33 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeRequestBody(t *testing.T) {
+	handler := Chain(okHandler, DecodeRequestBody(1<<20))
+
+	// Test: Unknown coding is refused with the codings we do accept
+	req, err := request.RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: br\r\nContent-Length: 4\r\n\r\nabcd"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	handler(response.NewWriter(&buf), req)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 415 Unsupported Media Type\r\n"), buf.String())
+	assert.Contains(t, buf.String(), "Accept-Encoding: gzip, deflate\r\n")
+
+	// Test: Plain bodies pass through
+	req, err = request.RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n\r\nabcd"))
+	require.NoError(t, err)
+	buf.Reset()
+	handler(response.NewWriter(&buf), req)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"), buf.String())
+}
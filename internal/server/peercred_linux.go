@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+)
+
+func peerCred(c net.Conn) *request.Credentials {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return nil
+	}
+	return &request.Credentials{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}
+}
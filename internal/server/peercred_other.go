@@ -0,0 +1,13 @@
+//go:build !linux
+
+package server
+
+import (
+	"net"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+)
+
+func peerCred(c net.Conn) *request.Credentials {
+	return nil
+}
@@ -1,11 +1,23 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"net"
+	"os"
+	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/felixsolom/http-from-tcp/internal/http2"
 	"github.com/felixsolom/http-from-tcp/internal/request"
 	"github.com/felixsolom/http-from-tcp/internal/response"
 )
@@ -16,31 +28,210 @@ type Server struct {
 	listener net.Listener
 	closed   atomic.Bool
 	handler  Handler
+	connIDs  atomic.Uint64
+	stats    stats
+	// ctx is cancelled by Close, taking every request context with it.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	stopOnce sync.Once
+	stopErr  error
+
+	connsMu sync.Mutex
+	conns   map[uint64]*trackedConn
+
+	network    string
+	host       string
+	socketMode os.FileMode
+	socketPath string
+	tlsConf    *tlsSettings
+	h2c        bool
+	writeBuf   int
+	reqTimeout time.Duration
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
-	portStr := fmt.Sprintf(":%d", port)
-	l, err := net.Listen("tcp", portStr)
-	if err != nil {
-		return nil, fmt.Errorf("Coudln't start server on port: %d", port)
+type Option func(*Server)
+
+// shutdownPollInterval is how often Shutdown looks for connections it can
+// close, and drainGrace how long it leaves new and idle ones alone.
+const (
+	shutdownPollInterval = 10 * time.Millisecond
+	drainGrace           = 500 * time.Millisecond
+)
+
+// WithNetwork picks the listening network: "tcp" (the default, dual stack),
+// "tcp4" or "tcp6".
+func WithNetwork(network string) Option {
+	return func(s *Server) { s.network = network }
+}
+
+// WithHost binds to a single local address instead of all interfaces.
+func WithHost(host string) Option {
+	return func(s *Server) { s.host = host }
+}
+
+// WithLocalhostOnly binds to the loopback address of the chosen network so
+// the server is unreachable from other machines.
+func WithLocalhostOnly() Option {
+	return func(s *Server) {
+		if s.network == "tcp6" {
+			s.host = "::1"
+		} else {
+			s.host = "127.0.0.1"
+		}
+	}
+}
+
+func newServer(handler Handler, opts []Option) *Server {
+	s := &Server{
+		handler:    handler,
+		network:    "tcp",
+		socketMode: defaultSocketMode,
 	}
-	server := &Server{
-		listener: l,
-		handler:  handler,
+	for _, opt := range opts {
+		opt(s)
 	}
-	go server.listen()
-	return server, nil
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
 }
 
-func (s *Server) Close() error {
-	s.closed.Store(true)
-	err := s.listener.Close()
+// WithH2C lets clients speak HTTP/2 without TLS, either right away with
+// prior knowledge or by upgrading from HTTP/1.1.
+func WithH2C() Option {
+	return func(s *Server) { s.h2c = true }
+}
+
+// WithWriteBuffer buffers HTTP/1 responses in size bytes, sent when full,
+// on Writer.Flush and once the handler returns. By default every write goes
+// straight to the connection.
+func WithWriteBuffer(size int) Option {
+	return func(s *Server) { s.writeBuf = size }
+}
+
+// WithRequestTimeout puts a deadline d from the start of each handler on
+// the request's context. Handlers that watch the context stop work then,
+// others aren't interrupted.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *Server) { s.reqTimeout = d }
+}
+
+// Serve listens on port, 0 meaning any free one, and serves handler on it.
+// Options are applied in order, so WithLocalhostOnly should come after
+// WithNetwork.
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	s := newServer(handler, opts)
+	addr := net.JoinHostPort(s.host, strconv.Itoa(port))
+	l, err := net.Listen(s.network, addr)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("couldn't start server on %s %s: %w", s.network, addr, err)
+	}
+	if err := s.start(l); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ServeListener serves handler on an already open listener, which the
+// server owns from then on. Bind options have no effect here.
+func ServeListener(l net.Listener, handler Handler, opts ...Option) (*Server, error) {
+	s := newServer(handler, opts)
+	if err := s.start(l); err != nil {
+		return nil, err
 	}
+	return s, nil
+}
+
+func (s *Server) start(l net.Listener) error {
+	if s.tlsConf != nil {
+		config, err := s.tlsConf.config()
+		if err != nil {
+			l.Close()
+			return err
+		}
+		l = tls.NewListener(l, config)
+	}
+	s.listener = l
+	go s.listen()
 	return nil
 }
 
+// Addr is the address the server actually listens on, which tells the port
+// chosen when serving on port 0.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops listening and cancels every request context. Handlers that
+// don't watch their context keep running.
+func (s *Server) Close() error {
+	err := s.stopListening()
+	s.cancel()
+	return err
+}
+
+// Shutdown stops listening, then waits for the requests in flight to
+// finish while closing connections that go idle. Once ctx is done the
+// remaining requests are cancelled, their connections closed, and ctx's
+// error returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.stopListening()
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeDrainable() == 0 {
+			s.cancel()
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.cancel()
+			for _, tc := range s.tracked() {
+				tc.conn.Close()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeDrainable closes the connections Shutdown doesn't have to wait
+// for and counts the ones left open.
+func (s *Server) closeDrainable() int {
+	open := 0
+	for _, tc := range s.tracked() {
+		if tc.drainable() {
+			tc.conn.Close()
+		} else {
+			open++
+		}
+	}
+	return open
+}
+
+func (s *Server) tracked() []*trackedConn {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	conns := make([]*trackedConn, 0, len(s.conns))
+	for _, tc := range s.conns {
+		conns = append(conns, tc)
+	}
+	return conns
+}
+
+// stopListening closes the listener once, no matter how often it's called.
+func (s *Server) stopListening() error {
+	s.stopOnce.Do(func() {
+		s.closed.Store(true)
+		s.stopErr = s.listener.Close()
+		if s.socketPath != "" {
+			if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, fs.ErrNotExist) && s.stopErr == nil {
+				s.stopErr = err
+			}
+		}
+	})
+	return s.stopErr
+}
+
 func (s *Server) listen() {
 	for {
 		// Wait for a connection.
@@ -57,17 +248,116 @@ func (s *Server) listen() {
 }
 
 func (s *Server) handle(conn net.Conn) {
-	go func(c net.Conn) {
-		defer c.Close()
-		w := response.NewWriter(c)
-		req, err := request.RequestFromReader(c)
+	s.stats.acceptedConns.Add(1)
+	s.stats.activeConns.Add(1)
+	tracked := s.track(conn, s.connIDs.Add(1))
+	go func(raw net.Conn) {
+		defer s.stats.activeConns.Add(-1)
+		defer s.untrack(tracked)
+		handler := s.connHandler(raw, tracked)
+		c := &countingConn{Conn: raw, stats: &s.stats, tracked: tracked}
+		hijacked := false
+		defer func() {
+			if !hijacked {
+				c.Close()
+			}
+		}()
+		tc, isTLS := raw.(*tls.Conn)
+		if isTLS {
+			raw.SetDeadline(time.Now().Add(handshakeTimeout))
+			if err := tc.Handshake(); err != nil {
+				log.Println("TLS handshake error:", err)
+				return
+			}
+			raw.SetDeadline(time.Time{})
+		}
+		r := bufio.NewReader(c)
+		if s.h2c && !isTLS && http2.HasPreface(r) {
+			tracked.setProto("HTTP/2")
+			if err := http2.ServeConn(c, r, handler); err != nil {
+				log.Println("HTTP/2 connection error:", err)
+			}
+			return
+		}
+
+		req, rest, err := request.ReadRequest(r)
 		if err != nil {
+			s.stats.parseError(err)
+			w := response.NewWriter(c)
 			w.WriteStatusLine(response.BadRequest)
 			body := []byte(fmt.Sprintf("error parsing request: %v", err))
 			w.WriteHeaders(response.GetDefaultHeaders(len(body)))
 			w.WriteBody(body)
 			return
 		}
-		s.handler(w, req)
+		// whatever the parser read ahead comes before what's still
+		// buffered in r
+		rest = append(rest, peekBuffered(r)...)
+		if s.h2c && !isTLS && http2.IsUpgrade(req) {
+			err := http2.ServeUpgrade(c, io.MultiReader(bytes.NewReader(rest), c), req, handler)
+			if !errors.Is(err, http2.ErrBadUpgrade) {
+				if err != nil {
+					log.Println("HTTP/2 connection error:", err)
+				}
+				return
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		wc := watchConn(c, cancel)
+		w := response.NewConnWriter(wc, rest, s.writeBuf)
+		handler(w, req.WithContext(ctx))
+		if w.Hijacked() {
+			hijacked = true
+			return
+		}
+		if err := w.Finish(); err != nil {
+			log.Println("Couldn't finish response:", err)
+		}
 	}(conn)
 }
+
+// peekBuffered returns what r has buffered without consuming it.
+func peekBuffered(r *bufio.Reader) []byte {
+	b, _ := r.Peek(r.Buffered())
+	return b
+}
+
+// connHandler wraps the handler to fill in what the request knows about
+// the connection it came in on, and to keep the connection's record up to
+// date. Its context is cancelled when the handler returns, the server closes
+// or the request timeout passes.
+func (s *Server) connHandler(c net.Conn, tracked *trackedConn) func(w *response.Writer, req *request.Request) {
+	var seq atomic.Uint64
+	return func(w *response.Writer, req *request.Request) {
+		ctx := context.WithValue(req.Context(), localAddrKey, c.LocalAddr())
+		ctx = context.WithValue(ctx, remoteAddrKey, c.RemoteAddr())
+		var cancel context.CancelFunc
+		if s.reqTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, s.reqTimeout)
+		} else {
+			ctx, cancel = context.WithCancel(ctx)
+		}
+		defer cancel()
+		stop := context.AfterFunc(s.ctx, cancel)
+		defer stop()
+		req = req.WithContext(ctx)
+
+		req.RemoteAddr = c.RemoteAddr().String()
+		req.LocalAddr = c.LocalAddr().String()
+		req.ConnID = tracked.id
+		req.Seq = seq.Add(1)
+		if req.Seq > 1 {
+			s.stats.reusedRequests.Add(1)
+		}
+		req.PeerCred = peerCred(c)
+		if tc, ok := c.(*tls.Conn); ok {
+			state := tc.ConnectionState()
+			req.TLS = &state
+		}
+		tracked.startRequest(req)
+		defer tracked.endRequest(req.Seq)
+		s.handler(w, req)
+	}
+}
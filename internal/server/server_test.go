@@ -0,0 +1,387 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/http2"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2/hpack"
+)
+
+func okHandler(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(0))
+}
+
+func roundTrip(t *testing.T, addr string) string {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(raw)
+}
+
+func TestServe(t *testing.T) {
+	// Test: Port 0 picks a free port
+	s, err := Serve(0, okHandler, WithLocalhostOnly())
+	require.NoError(t, err)
+	defer s.Close()
+	addr := s.Addr().(*net.TCPAddr)
+	assert.NotZero(t, addr.Port)
+	assert.True(t, addr.IP.IsLoopback())
+	assert.Contains(t, roundTrip(t, addr.String()), "HTTP/1.1 200 OK\r\n")
+
+	// Test: Port in use surfaces the syscall error
+	_, err = Serve(addr.Port, okHandler, WithLocalhostOnly())
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, syscall.EADDRINUSE))
+
+	// Test: IPv4 only
+	s4, err := Serve(0, okHandler, WithNetwork("tcp4"), WithLocalhostOnly())
+	require.NoError(t, err)
+	defer s4.Close()
+	assert.NotNil(t, s4.Addr().(*net.TCPAddr).IP.To4())
+}
+
+func TestServeListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s, err := ServeListener(l, okHandler)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, l.Addr(), s.Addr())
+	assert.Contains(t, roundTrip(t, l.Addr().String()), "HTTP/1.1 200 OK\r\n")
+}
+
+func TestServeH2C(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		body := []byte(fmt.Sprintf("%s %s %d", req.RequestLine.HttpVersion, req.RemoteAddr, req.Seq))
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, WithLocalhostOnly(), WithH2C())
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: HTTP/1.1 still works next to prior knowledge
+	assert.Contains(t, roundTrip(t, s.Addr().String()), "HTTP/1.1 200 OK\r\n")
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	for _, f := range [][2]string{{":method", "GET"}, {":scheme", "http"}, {":authority", "localhost"}, {":path", "/"}} {
+		enc.WriteField(hpack.HeaderField{Name: f[0], Value: f[1]})
+	}
+	_, err = io.WriteString(conn, http2.Preface)
+	require.NoError(t, err)
+	require.NoError(t, http2.WriteFrame(conn, http2.FrameSettings, 0, 0, nil))
+	require.NoError(t, http2.WriteFrame(conn, http2.FrameHeaders, http2.FlagEndHeaders|http2.FlagEndStream, 1, block.Bytes()))
+	require.NoError(t, http2.WriteFrame(conn, http2.FrameHeaders, http2.FlagEndHeaders|http2.FlagEndStream, 3, block.Bytes()))
+
+	// Test: Streams count as requests on the connection
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	bodies := map[uint32]string{}
+	for len(bodies) < 2 {
+		f, err := http2.ReadFrame(conn, 1<<24-1)
+		require.NoError(t, err)
+		if f.Type == http2.FrameData && len(f.Payload) > 0 {
+			bodies[f.StreamID] = string(f.Payload)
+		}
+	}
+	seqs := []string{bodies[1], bodies[3]}
+	assert.ElementsMatch(t, []string{"2 " + conn.LocalAddr().String() + " 1", "2 " + conn.LocalAddr().String() + " 2"}, seqs)
+}
+
+func TestHijack(t *testing.T) {
+	// Test: CONNECT style tunnel gets the bytes sent right after the request
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			response.WriteError(w, response.InternalServerError, err.Error())
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+		conn.Write(buffered)
+		io.Copy(conn, conn)
+	}, WithLocalhostOnly())
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\nearly bytes|"))
+	require.NoError(t, err)
+
+	want := "HTTP/1.1 200 Connection Established\r\n\r\nearly bytes|"
+	got := make([]byte, len(want))
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(got))
+
+	// Test: Connection now belongs to the handler
+	_, err = conn.Write([]byte("late bytes"))
+	require.NoError(t, err)
+	got = make([]byte, len("late bytes"))
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	assert.Equal(t, "late bytes", string(got))
+}
+
+func TestRequestContext(t *testing.T) {
+	cancelled := make(chan error, 1)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		select {
+		case <-req.Context().Done():
+			cancelled <- req.Context().Err()
+		case <-time.After(2 * time.Second):
+			cancelled <- nil
+		}
+	}, WithLocalhostOnly())
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Client hanging up cancels the context
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+
+	// Test: Closing the server cancels it too
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+}
+
+func TestFlush(t *testing.T) {
+	// Test: Flushed chunks reach the client while the handler still runs
+	release := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("first"))
+		w.Flush()
+		<-release
+		w.WriteChunkedBody([]byte("second"))
+		w.WriteChunkedBodyDone()
+	}, WithLocalhostOnly(), WithWriteBuffer(4096))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	var got []byte
+	buf := make([]byte, 1024)
+	for !bytes.Contains(got, []byte("first")) {
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		got = append(got, buf[:n]...)
+	}
+	assert.NotContains(t, string(got), "second")
+	close(release)
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(rest), "6\r\nsecond\r\n0\r\n\r\n"))
+}
+
+func TestRequestContextValues(t *testing.T) {
+	ctxs := make(chan context.Context, 1)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		ctxs <- req.Context()
+		okHandler(w, req)
+	}, WithLocalhostOnly(), WithRequestTimeout(time.Minute))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	ctx := <-ctxs
+
+	// Test: Both ends of the connection
+	local, ok := LocalAddr(ctx)
+	require.True(t, ok)
+	assert.Equal(t, conn.RemoteAddr().String(), local.String())
+	remote, ok := RemoteAddr(ctx)
+	require.True(t, ok)
+	assert.Equal(t, conn.LocalAddr().String(), remote.String())
+
+	// Test: Deadline from the request timeout
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+
+	// Test: Cancelled once the handler returned
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+
+	// Test: Nothing outside a server
+	_, ok = LocalAddr(context.Background())
+	assert.False(t, ok)
+}
+
+func TestConnMetadata(t *testing.T) {
+	reqs := make(chan *request.Request, 2)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		reqs <- req
+		okHandler(w, req)
+	}, WithLocalhostOnly())
+	require.NoError(t, err)
+	defer s.Close()
+
+	start := time.Now()
+	roundTrip(t, s.Addr().String())
+	roundTrip(t, s.Addr().String())
+	first, second := <-reqs, <-reqs
+
+	// Test: Addresses, sequence and receive time
+	assert.Equal(t, s.Addr().String(), first.LocalAddr)
+	assert.NotEmpty(t, first.RemoteAddr)
+	assert.Equal(t, uint64(1), first.Seq)
+	assert.False(t, first.ReceivedAt.Before(start))
+	assert.False(t, first.ReceivedAt.After(time.Now()))
+
+	// Test: Every connection gets its own ID
+	assert.NotZero(t, first.ConnID)
+	assert.NotEqual(t, first.ConnID, second.ConnID)
+}
+
+// startRequest sends a request to addr without waiting for the response.
+func startRequest(t *testing.T, addr, target string) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: localhost\r\n\r\n", target)
+	require.NoError(t, err)
+	return conn
+}
+
+func TestConns(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+		okHandler(w, req)
+	}, WithLocalhostOnly())
+	require.NoError(t, err)
+	defer s.Close()
+
+	busy := startRequest(t, s.Addr().String(), "/slow?x=1")
+	defer busy.Close()
+	<-started
+	quiet, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer quiet.Close()
+	require.Eventually(t, func() bool { return len(s.Conns()) == 2 }, time.Second, 5*time.Millisecond)
+
+	// Test: The busy connection shows its request, the other one nothing yet
+	conns := s.Conns()
+	assert.Equal(t, StateActive, conns[0].State)
+	assert.Equal(t, busy.LocalAddr().String(), conns[0].RemoteAddr)
+	assert.Equal(t, "HTTP/1.1", conns[0].Proto)
+	assert.NotZero(t, conns[0].BytesIn)
+	require.Len(t, conns[0].Requests, 1)
+	assert.Equal(t, "GET", conns[0].Requests[0].Method)
+	assert.Equal(t, "/slow?x=1", conns[0].Requests[0].Target)
+	assert.Equal(t, StateNew, conns[1].State)
+	assert.Empty(t, conns[1].Requests)
+
+	// Test: Finished connections are dropped
+	close(release)
+	_, err = io.ReadAll(busy)
+	require.NoError(t, err)
+	quiet.Close()
+	assert.Eventually(t, func() bool { return len(s.Conns()) == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+		okHandler(w, req)
+	}, WithLocalhostOnly())
+	require.NoError(t, err)
+	addr := s.Addr().String()
+
+	conn := startRequest(t, addr, "/")
+	defer conn.Close()
+	<-started
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+
+	// Test: No new connections, but the request in flight is waited for
+	require.Eventually(t, func() bool {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			c.Close()
+		}
+		return err != nil
+	}, time.Second, 5*time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned with a request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "HTTP/1.1 200 OK\r\n")
+	require.NoError(t, <-done)
+
+	// Test: Past the deadline requests are cancelled
+	s, err = Serve(0, func(w *response.Writer, req *request.Request) {
+		started <- struct{}{}
+		<-req.Context().Done()
+	}, WithLocalhostOnly())
+	require.NoError(t, err)
+	conn = startRequest(t, s.Addr().String(), "/")
+	defer conn.Close()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+	assert.NoError(t, s.Close())
+}
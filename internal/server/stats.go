@@ -0,0 +1,104 @@
+package server
+
+import (
+	"errors"
+	"maps"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+)
+
+// Stats is a snapshot of the server's counters since it started.
+type Stats struct {
+	ActiveConns   int64
+	AcceptedConns uint64
+	// BytesIn and BytesOut count what went over the connections, after
+	// TLS decryption and before encryption.
+	BytesIn  uint64
+	BytesOut uint64
+	// ReusedRequests counts requests beyond the first on their
+	// connection, whether they came over keep-alive or as further HTTP/2
+	// streams. It says nothing about how many connections were reused.
+	ReusedRequests uint64
+	// ParseErrors counts unparseable requests by ParseErrorType.
+	ParseErrors map[string]uint64
+}
+
+type stats struct {
+	activeConns    atomic.Int64
+	acceptedConns  atomic.Uint64
+	bytesIn        atomic.Uint64
+	bytesOut       atomic.Uint64
+	reusedRequests atomic.Uint64
+
+	mu          sync.Mutex
+	parseErrors map[string]uint64
+}
+
+// Stats returns the current counters.
+func (s *Server) Stats() Stats {
+	s.stats.mu.Lock()
+	parseErrors := maps.Clone(s.stats.parseErrors)
+	s.stats.mu.Unlock()
+	if parseErrors == nil {
+		parseErrors = map[string]uint64{}
+	}
+	return Stats{
+		ActiveConns:    s.stats.activeConns.Load(),
+		AcceptedConns:  s.stats.acceptedConns.Load(),
+		BytesIn:        s.stats.bytesIn.Load(),
+		BytesOut:       s.stats.bytesOut.Load(),
+		ReusedRequests: s.stats.reusedRequests.Load(),
+		ParseErrors:    parseErrors,
+	}
+}
+
+func (st *stats) parseError(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.parseErrors == nil {
+		st.parseErrors = map[string]uint64{}
+	}
+	st.parseErrors[ParseErrorType(err)]++
+}
+
+// ParseErrorType names the kind of a request parse error: "incomplete",
+// "request_line", "header", "content_length", or "read" when the
+// connection itself failed.
+func ParseErrorType(err error) string {
+	switch {
+	case errors.Is(err, request.ErrIncomplete):
+		return "incomplete"
+	case errors.Is(err, request.ErrMalformedRequestLine):
+		return "request_line"
+	case errors.Is(err, request.ErrMalformedHeader):
+		return "header"
+	case errors.Is(err, request.ErrBadContentLength):
+		return "content_length"
+	}
+	return "read"
+}
+
+// countingConn adds the bytes going through it to the server's stats and
+// to those of the connection.
+type countingConn struct {
+	net.Conn
+	stats   *stats
+	tracked *trackedConn
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.stats.bytesIn.Add(uint64(n))
+	c.tracked.bytesIn.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.stats.bytesOut.Add(uint64(n))
+	c.tracked.bytesOut.Add(uint64(n))
+	return n, err
+}
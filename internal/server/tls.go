@@ -0,0 +1,148 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// handshakeTimeout bounds the TLS handshake, a client that never finishes
+// it would otherwise hold the connection forever.
+const handshakeTimeout = 10 * time.Second
+
+type tlsSettings struct {
+	certs      []*certFiles
+	minVersion uint16
+	clientCAs  *x509.CertPool
+	clientAuth tls.ClientAuthType
+}
+
+func (s *Server) tlsSettings() *tlsSettings {
+	if s.tlsConf == nil {
+		s.tlsConf = &tlsSettings{minVersion: tls.VersionTLS12}
+	}
+	return s.tlsConf
+}
+
+// WithTLS serves HTTPS with the certificate and key in the given PEM files.
+// Called more than once it adds certificates for more hostnames, picked by
+// SNI, with the first one used when nothing else matches. The files are
+// reloaded when they change on disk.
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *Server) {
+		t := s.tlsSettings()
+		t.certs = append(t.certs, &certFiles{certPath: certFile, keyPath: keyFile})
+	}
+}
+
+// WithMinTLSVersion sets the oldest accepted protocol version, such as
+// tls.VersionTLS13. The default is TLS 1.2.
+func WithMinTLSVersion(version uint16) Option {
+	return func(s *Server) { s.tlsSettings().minVersion = version }
+}
+
+// WithClientAuth asks clients for a certificate signed by one of cas. When
+// required is false, clients without one are still served, but any
+// certificate they do send must verify.
+func WithClientAuth(cas *x509.CertPool, required bool) Option {
+	return func(s *Server) {
+		t := s.tlsSettings()
+		t.clientCAs = cas
+		t.clientAuth = tls.VerifyClientCertIfGiven
+		if required {
+			t.clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+}
+
+func (t *tlsSettings) config() (*tls.Config, error) {
+	if len(t.certs) == 0 {
+		return nil, errors.New("TLS options given without a certificate")
+	}
+	// Load everything once up front so a bad file fails Serve instead of
+	// every handshake.
+	for _, c := range t.certs {
+		if _, err := c.load(); err != nil {
+			return nil, err
+		}
+	}
+	return &tls.Config{
+		MinVersion:     t.minVersion,
+		ClientCAs:      t.clientCAs,
+		ClientAuth:     t.clientAuth,
+		GetCertificate: t.getCertificate,
+	}, nil
+}
+
+func (t *tlsSettings) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	var fallback *tls.Certificate
+	for _, c := range t.certs {
+		cert, err := c.load()
+		if err != nil {
+			log.Println("Couldn't load certificate:", err)
+			continue
+		}
+		if fallback == nil {
+			fallback = cert
+		}
+		if hello.ServerName != "" && cert.Leaf.VerifyHostname(hello.ServerName) == nil {
+			return cert, nil
+		}
+	}
+	if fallback == nil {
+		return nil, errors.New("no usable certificate")
+	}
+	return fallback, nil
+}
+
+// certFiles is a certificate and key on disk, reloaded whenever either
+// file's modification time changes. Checking costs two stats per handshake.
+type certFiles struct {
+	certPath, keyPath string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+func (c *certFiles) load() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	certInfo, certErr := os.Stat(c.certPath)
+	keyInfo, keyErr := os.Stat(c.keyPath)
+	if err := errors.Join(certErr, keyErr); err != nil {
+		return c.keep(err)
+	}
+	if c.cert != nil && certInfo.ModTime().Equal(c.certMod) && keyInfo.ModTime().Equal(c.keyMod) {
+		return c.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return c.keep(fmt.Errorf("couldn't load %s: %w", c.certPath, err))
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return c.keep(fmt.Errorf("couldn't parse %s: %w", c.certPath, err))
+		}
+	}
+	c.cert = &cert
+	c.certMod = certInfo.ModTime()
+	c.keyMod = keyInfo.ModTime()
+	return c.cert, nil
+}
+
+// keep holds on to the last good certificate when a reload fails, which is
+// usually a renewal caught halfway through writing the files.
+func (c *certFiles) keep(err error) (*tls.Certificate, error) {
+	if c.cert == nil {
+		return nil, err
+	}
+	log.Println("Keeping previous certificate:", err)
+	return c.cert, nil
+}
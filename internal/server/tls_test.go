@@ -0,0 +1,192 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue signs a leaf certificate for name and returns it as PEM cert and key.
+func (ca *testCA) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) writeServerCert(t *testing.T, dir, name string, serial int64) (string, string) {
+	certPEM, keyPEM := ca.issue(t, name, serial, x509.ExtKeyUsageServerAuth)
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+	return certFile, keyFile
+}
+
+func tlsHandler(w *response.Writer, req *request.Request) {
+	body := []byte("no client cert")
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		body = []byte("hello " + req.TLS.VerifiedChains[0][0].Subject.CommonName)
+	}
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// tlsGet performs a request and returns the response body along with the
+// certificate the server presented.
+func tlsGet(t *testing.T, addr string, config *tls.Config) (string, *x509.Certificate, error) {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")); err != nil {
+		return "", nil, err
+	}
+	raw, err := io.ReadAll(conn)
+	if err != nil {
+		return "", nil, err
+	}
+	_, body, _ := strings.Cut(string(raw), "\r\n\r\n")
+	return body, conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	aCert, aKey := ca.writeServerCert(t, dir, "a.example", 10)
+	bCert, bKey := ca.writeServerCert(t, dir, "b.example", 20)
+
+	s, err := Serve(0, tlsHandler, WithLocalhostOnly(), WithTLS(aCert, aKey), WithTLS(bCert, bKey))
+	require.NoError(t, err)
+	defer s.Close()
+	addr := s.Addr().String()
+
+	// Test: SNI picks the matching certificate
+	body, cert, err := tlsGet(t, addr, &tls.Config{RootCAs: ca.pool, ServerName: "b.example"})
+	require.NoError(t, err)
+	assert.Equal(t, "no client cert", body)
+	assert.Equal(t, "b.example", cert.Subject.CommonName)
+
+	// Test: Unknown names get the first certificate
+	_, cert, err = tlsGet(t, addr, &tls.Config{InsecureSkipVerify: true, ServerName: "c.example"})
+	require.NoError(t, err)
+	assert.Equal(t, "a.example", cert.Subject.CommonName)
+
+	// Test: Rewritten files are picked up without a restart
+	certPEM, keyPEM := ca.issue(t, "a.example", 11, x509.ExtKeyUsageServerAuth)
+	require.NoError(t, os.WriteFile(aCert, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(aKey, keyPEM, 0o600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(aCert, later, later))
+	require.NoError(t, os.Chtimes(aKey, later, later))
+	_, cert, err = tlsGet(t, addr, &tls.Config{RootCAs: ca.pool, ServerName: "a.example"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), cert.SerialNumber.Int64())
+
+	// Test: A broken reload keeps the previous certificate
+	require.NoError(t, os.WriteFile(aCert, []byte("garbage"), 0o600))
+	_, cert, err = tlsGet(t, addr, &tls.Config{RootCAs: ca.pool, ServerName: "a.example"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), cert.SerialNumber.Int64())
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.writeServerCert(t, t.TempDir(), "a.example", 10)
+	s, err := Serve(0, tlsHandler, WithLocalhostOnly(), WithTLS(certFile, keyFile), WithMinTLSVersion(tls.VersionTLS13))
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, _, err = tlsGet(t, s.Addr().String(), &tls.Config{RootCAs: ca.pool, ServerName: "a.example", MaxVersion: tls.VersionTLS12})
+	assert.Error(t, err)
+	_, _, err = tlsGet(t, s.Addr().String(), &tls.Config{RootCAs: ca.pool, ServerName: "a.example"})
+	assert.NoError(t, err)
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.writeServerCert(t, t.TempDir(), "a.example", 10)
+	s, err := Serve(0, tlsHandler, WithLocalhostOnly(), WithTLS(certFile, keyFile), WithClientAuth(ca.pool, true))
+	require.NoError(t, err)
+	defer s.Close()
+
+	clientPEM, clientKey := ca.issue(t, "alice", 30, x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKey)
+	require.NoError(t, err)
+
+	// Test: The verified chain reaches the handler
+	body, _, err := tlsGet(t, s.Addr().String(), &tls.Config{
+		RootCAs:      ca.pool,
+		ServerName:   "a.example",
+		Certificates: []tls.Certificate{clientCert},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello alice", body)
+
+	// Test: No certificate, no service
+	_, _, err = tlsGet(t, s.Addr().String(), &tls.Config{RootCAs: ca.pool, ServerName: "a.example"})
+	assert.Error(t, err)
+}
+
+func TestTLSBadFiles(t *testing.T) {
+	_, err := Serve(0, tlsHandler, WithLocalhostOnly(), WithTLS("missing.crt", "missing.key"))
+	assert.Error(t, err)
+}
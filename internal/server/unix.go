@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"syscall"
+)
+
+const defaultSocketMode os.FileMode = 0o660
+
+// WithSocketMode sets the permissions of the socket file created by
+// ServeUnix, 0660 by default.
+func WithSocketMode(mode os.FileMode) Option {
+	return func(s *Server) { s.socketMode = mode }
+}
+
+// ServeUnix serves handler on a Unix domain socket at path. A socket file
+// left behind by a server that's no longer running is removed first; one
+// that still accepts connections is an error. Close removes the file.
+func ServeUnix(path string, handler Handler, opts ...Option) (*Server, error) {
+	s := newServer(handler, opts)
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't start server on unix %s: %w", path, err)
+	}
+	if err := os.Chmod(path, s.socketMode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("couldn't set mode of %s: %w", path, err)
+	}
+	s.socketPath = path
+	if err := s.start(l); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return s, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s: %w", path, syscall.EADDRINUSE)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("couldn't probe %s: %w", path, err)
+	}
+	return os.Remove(path)
+}
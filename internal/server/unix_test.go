@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func credHandler(w *response.Writer, req *request.Request) {
+	body := []byte("none")
+	if req.PeerCred != nil {
+		body = []byte(fmt.Sprintf("%d %d %d", req.PeerCred.PID, req.PeerCred.UID, req.PeerCred.GID))
+	}
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func unixRoundTrip(t *testing.T, path string) string {
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(raw)
+}
+
+func TestServeUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+
+	// Test: Serves with the requested mode and peer credentials
+	s, err := ServeUnix(path, credHandler, WithSocketMode(0o600))
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	raw := unixRoundTrip(t, path)
+	assert.Contains(t, raw, "HTTP/1.1 200 OK\r\n")
+	if runtime.GOOS == "linux" {
+		assert.Contains(t, raw, fmt.Sprintf("\r\n\r\n%d %d %d", os.Getpid(), os.Getuid(), os.Getgid()))
+	}
+
+	// Test: A live socket is not taken over
+	_, err = ServeUnix(path, credHandler)
+	assert.True(t, errors.Is(err, syscall.EADDRINUSE))
+
+	// Test: Close removes the socket file
+	require.NoError(t, s.Close())
+	_, err = os.Stat(path)
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestServeUnixStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+
+	// Test: A socket left behind by a dead server is replaced
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	s, err := ServeUnix(path, credHandler)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Contains(t, unixRoundTrip(t, path), "HTTP/1.1 200 OK\r\n")
+
+	// Test: Anything that isn't a socket is left alone
+	file := filepath.Join(t.TempDir(), "regular")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+	_, err = ServeUnix(file, credHandler)
+	assert.Error(t, err)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// watchedConn notices a client hanging up while a handler runs by keeping a
+// one byte read pending on the otherwise idle connection.
+type watchedConn struct {
+	net.Conn
+	done chan struct{}
+	once sync.Once
+	buf  [1]byte
+	n    int
+}
+
+// watchConn starts the pending read, calling gone if it fails.
+func watchConn(c net.Conn, gone func()) *watchedConn {
+	wc := &watchedConn{Conn: c, done: make(chan struct{})}
+	go func() {
+		defer close(wc.done)
+		n, err := c.Read(wc.buf[:])
+		wc.n = n
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			gone()
+		}
+	}()
+	return wc
+}
+
+// stopWatching ends the pending read by moving the read deadline into the
+// past, then clears the deadline again.
+func (wc *watchedConn) stopWatching() {
+	wc.once.Do(func() {
+		wc.Conn.SetReadDeadline(time.Unix(1, 0))
+		<-wc.done
+		wc.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+// Read stops watching first, so whoever reads next, like a handler that
+// hijacked the connection, gets the byte the watch may have picked up.
+func (wc *watchedConn) Read(p []byte) (int, error) {
+	wc.stopWatching()
+	if wc.n > 0 && len(p) > 0 {
+		p[0] = wc.buf[0]
+		wc.n = 0
+		return 1, nil
+	}
+	return wc.Conn.Read(p)
+}
@@ -0,0 +1,102 @@
+package servertest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/server"
+)
+
+// DefaultRemoteAddr is the peer address requests made by NewRequest claim
+// to come from, taken from the TEST-NET-1 documentation range.
+const DefaultRemoteAddr = "192.0.2.1:1234"
+
+// Recorder captures everything a handler writes.
+type Recorder struct {
+	Writer *response.Writer
+	buf    bytes.Buffer
+}
+
+func NewRecorder() *Recorder {
+	r := &Recorder{}
+	r.Writer = response.NewWriter(&r.buf)
+	return r
+}
+
+// Bytes returns the raw response as written so far.
+func (r *Recorder) Bytes() []byte {
+	return r.buf.Bytes()
+}
+
+// Result finishes the response and parses it.
+func (r *Recorder) Result() (*response.Response, error) {
+	if err := r.Writer.Finish(); err != nil {
+		return nil, err
+	}
+	return response.ResponseFromReader(bytes.NewReader(r.buf.Bytes()))
+}
+
+// NewRequest builds a request the way the parser would have, with lowercased
+// header names, a Host and a Content-Length matching body.
+func NewRequest(method, target string, body []byte) *request.Request {
+	h := headers.NewHeaders()
+	h["host"] = "localhost"
+	if len(body) > 0 {
+		h["content-length"] = strconv.Itoa(len(body))
+	}
+	if body == nil {
+		body = []byte{}
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			HttpVersion:   "1.1",
+			RequestTarget: target,
+			Method:        method,
+		},
+		Headers:    h,
+		Body:       body,
+		RemoteAddr: DefaultRemoteAddr,
+		Seq:        1,
+		ReceivedAt: time.Now(),
+	}
+}
+
+// Record runs handler for req and returns the parsed response.
+func Record(handler server.Handler, req *request.Request) (*response.Response, error) {
+	rec := NewRecorder()
+	handler(rec.Writer, req)
+	return rec.Result()
+}
+
+// Server is a real server listening on a random local port.
+type Server struct {
+	// Addr is the host:port the server listens on and URL the same as
+	// an http:// URL.
+	Addr   string
+	URL    string
+	server *server.Server
+}
+
+// NewServer starts handler on an ephemeral port of the loopback interface.
+func NewServer(handler server.Handler) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't listen on loopback: %w", err)
+	}
+	s, err := server.ServeListener(l, handler)
+	if err != nil {
+		return nil, err
+	}
+	addr := s.Addr().String()
+	return &Server{Addr: addr, URL: "http://" + addr, server: s}, nil
+}
+
+func (s *Server) Close() error {
+	return s.server.Close()
+}
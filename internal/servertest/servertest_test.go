@@ -0,0 +1,45 @@
+package servertest
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/felixsolom/http-from-tcp/internal/client"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + string(req.Body))
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestRecord(t *testing.T) {
+	req := NewRequest("POST", "/echo", []byte("hi"))
+	assert.Equal(t, "2", req.Headers["content-length"])
+	assert.Equal(t, DefaultRemoteAddr, req.RemoteAddr)
+
+	res, err := Record(echoHandler, req)
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, res.StatusLine.StatusCode)
+	assert.Equal(t, "POST /echo hi", string(res.Body))
+}
+
+func TestNewServer(t *testing.T) {
+	s, err := NewServer(echoHandler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	res, err := (&client.Client{}).Get(context.Background(), s.URL+"/over/the/wire")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "GET /over/the/wire ", string(body))
+}
@@ -0,0 +1,209 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+)
+
+const defaultHeartbeat = 15 * time.Second
+
+var ErrClosed = errors.New("sse: stream closed")
+
+// Event is one server-sent event. Empty fields are left out, Data may span
+// several lines.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+type Option func(*Stream)
+
+// WithHeartbeat sends a comment every d so proxies keep the connection open
+// and a gone client is noticed. Zero turns heartbeats off, the default is
+// 15 seconds.
+func WithHeartbeat(d time.Duration) Option {
+	return func(s *Stream) { s.heartbeat = d }
+}
+
+// Stream writes events to one client. It's safe for concurrent use.
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+	heartbeat   time.Duration
+
+	mu sync.Mutex
+	// err tells why the stream ended, broken that the response can't be
+	// completed anymore.
+	err       error
+	broken    bool
+	closed    bool
+	done      chan struct{}
+	stopWatch func() bool
+}
+
+// NewStream starts an event stream response on w. The handler should keep
+// sending until Done is closed and call Close when it's finished.
+func NewStream(w *response.Writer, req *request.Request, opts ...Option) (*Stream, error) {
+	s := &Stream{
+		w:         w,
+		heartbeat: defaultHeartbeat,
+		done:      make(chan struct{}),
+	}
+	s.lastEventID, _ = req.Headers.Lookup("Last-Event-ID")
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "close")
+	h.Set("Transfer-Encoding", "chunked")
+	if err := w.WriteStatusLine(response.OK); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	s.stopWatch = context.AfterFunc(req.Context(), func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.err == nil {
+			s.end(fmt.Errorf("sse: %w", context.Cause(req.Context())))
+		}
+	})
+	if s.heartbeat > 0 {
+		go s.beat()
+	}
+	return s, nil
+}
+
+// LastEventID is the ID of the last event a reconnecting client saw, empty
+// on the first connection.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed once the stream ended, because the request's context was
+// cancelled, a write failed after the client went away or Close was called.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Send writes e. ID and Event can't contain line breaks.
+func (s *Stream) Send(e Event) error {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return fmt.Errorf("sse: invalid event id %q", e.ID)
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return fmt.Errorf("sse: invalid event name %q", e.Event)
+	}
+
+	var b strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", e.ID)
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Event)
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	if e.Data != "" || b.Len() == 0 {
+		for _, line := range splitLines(e.Data) {
+			fmt.Fprintf(&b, "data: %s\n", line)
+		}
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		fmt.Fprintf(&b, ": %s\n", line)
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Close stops heartbeats and ends the response.
+func (s *Stream) Close() error {
+	s.stopWatch()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.err == nil {
+		s.end(ErrClosed)
+	}
+	if s.broken {
+		return nil
+	}
+	_, err := s.w.WriteChunkedBodyDone()
+	return err
+}
+
+func (s *Stream) write(p string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	_, err := s.w.WriteChunkedBody([]byte(p))
+	if err == nil {
+		err = s.w.Flush()
+	}
+	if err != nil {
+		s.broken = true
+		s.end(fmt.Errorf("sse: client gone: %w", err))
+		return s.err
+	}
+	return nil
+}
+
+// end records why the stream stopped, s.mu must be held.
+func (s *Stream) end(err error) {
+	s.err = err
+	close(s.done)
+}
+
+func (s *Stream) beat() {
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.Comment("heartbeat"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// splitLines splits on any of the line endings the event stream format
+// accepts, so a stray CR can't end a field early.
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
@@ -0,0 +1,89 @@
+package sse
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSend(t *testing.T) {
+	rec := servertest.NewRecorder()
+	req := servertest.NewRequest("GET", "/events", nil)
+	req.Headers["last-event-id"] = "41"
+	s, err := NewStream(rec.Writer, req, WithHeartbeat(0))
+	require.NoError(t, err)
+
+	// Test: Last-Event-ID is exposed
+	assert.Equal(t, "41", s.LastEventID())
+
+	// Test: Every field, multi-line data split on any line ending
+	require.NoError(t, s.Send(Event{ID: "42", Event: "progress", Data: "one\ntwo\r\nthree\rfour", Retry: 3 * time.Second}))
+	require.NoError(t, s.Send(Event{Data: "plain"}))
+	require.NoError(t, s.Comment("just saying"))
+
+	// Test: Line breaks can't sneak into single line fields
+	assert.Error(t, s.Send(Event{ID: "1\n2"}))
+	assert.Error(t, s.Send(Event{Event: "a\rb"}))
+
+	require.NoError(t, s.Close())
+	assert.ErrorIs(t, s.Send(Event{Data: "late"}), ErrClosed)
+	<-s.Done()
+
+	res, err := rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, res.StatusLine.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Headers["content-type"])
+	assert.Equal(t, "no-cache", res.Headers["cache-control"])
+	assert.Equal(t, "id: 42\nevent: progress\nretry: 3000\ndata: one\ndata: two\ndata: three\ndata: four\n\n"+
+		"data: plain\n\n"+
+		": just saying\n\n", string(res.Body))
+}
+
+func TestDisconnect(t *testing.T) {
+	gone := make(chan error, 1)
+	srv, err := servertest.NewServer(func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, WithHeartbeat(10*time.Millisecond))
+		if err != nil {
+			gone <- err
+			return
+		}
+		s.Send(Event{Data: "hello"})
+		<-s.Done()
+		gone <- s.Send(Event{Data: "anyone?"})
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Addr)
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = io.WriteString(conn, "GET /events HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if strings.Contains(line, "data: hello") {
+			break
+		}
+	}
+
+	// Test: Heartbeats notice the client went away
+	conn.Close()
+	select {
+	case err := <-gone:
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrClosed)
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream didn't notice the disconnect")
+	}
+}
@@ -0,0 +1,40 @@
+package tracing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/server"
+)
+
+// Middleware runs every request in a server span, continuing the trace the
+// client sent in traceparent if any. Handlers find the span with
+// SpanFromContext(req.Context()).
+func Middleware(t *Tracer) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			ctx := req.Context()
+			if remote, ok := Extract(req.Headers); ok {
+				ctx = ContextWithRemote(ctx, remote)
+			}
+			path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+			ctx, span := t.Start(ctx, req.RequestLine.Method+" "+path, KindServer)
+			defer span.End()
+			span.SetAttribute("http.request.method", req.RequestLine.Method)
+			span.SetAttribute("url.target", req.RequestLine.RequestTarget)
+			span.SetAttribute("network.protocol.version", req.RequestLine.HttpVersion)
+			span.SetAttribute("client.address", req.RemoteAddr)
+
+			next(w, req.WithContext(ctx))
+
+			status := w.StatusCode()
+			span.SetAttribute("http.response.status_code", strconv.Itoa(int(status)))
+			if status >= 500 {
+				span.SetError(fmt.Errorf("status %d", status))
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+)
+
+type Kind string
+
+const (
+	KindServer   Kind = "server"
+	KindClient   Kind = "client"
+	KindInternal Kind = "internal"
+)
+
+// Exporter sends finished spans somewhere. It's called from whichever
+// goroutine ends a span, so it has to be safe for concurrent use.
+type Exporter interface {
+	Export(span *Span) error
+}
+
+// Tracer starts spans and hands the sampled ones to its exporter when they
+// end.
+type Tracer struct {
+	exporter Exporter
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Span is one timed operation. Its fields are only safe to read once it
+// ended, which is when exporters see it. Methods on a nil *Span do nothing,
+// so optional tracing needs no checks.
+type Span struct {
+	Name       string            `json:"name"`
+	Kind       Kind              `json:"kind"`
+	TraceID    TraceID           `json:"trace_id"`
+	SpanID     SpanID            `json:"span_id"`
+	Parent     SpanID            `json:"parent_span_id,omitzero"`
+	StartTime  time.Time         `json:"start"`
+	EndTime    time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+
+	context SpanContext
+	tracer  *Tracer
+	mu      sync.Mutex
+	ended   bool
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// Start begins a span as a child of the span in ctx, or of a remote parent
+// put there by ContextWithRemote, or as the root of a new trace. The
+// returned context carries the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		SpanID:     newSpanID(),
+		StartTime:  time.Now(),
+		Attributes: map[string]string{},
+		tracer:     t,
+	}
+	parent, ok := spanContext(ctx)
+	if ok {
+		span.TraceID = parent.TraceID
+		span.Parent = parent.SpanID
+		span.context = SpanContext{TraceID: parent.TraceID, SpanID: span.SpanID, Flags: parent.Flags, TraceState: parent.TraceState}
+	} else {
+		span.TraceID = newTraceID()
+		span.context = SpanContext{TraceID: span.TraceID, SpanID: span.SpanID, Flags: flagSampled}
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SpanContext is what a child of s in another service gets told.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.Attributes[key] = value
+	}
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended && err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// End finishes the span and exports it when it's sampled. Only the first
+// call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+	if s.context.Sampled() && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+// SpanFromContext returns the span ctx carries, nil when there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemote makes sc, received from another service, the parent of
+// spans started from the returned context.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func spanContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.context, true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Extract reads the trace context another service sent in h. tracestate is
+// ignored without a valid traceparent.
+func Extract(h headers.Headers) (SpanContext, bool) {
+	traceparent, ok := h.Lookup("traceparent")
+	if !ok {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	if tracestate, ok := h.Lookup("tracestate"); ok && len(tracestate) <= maxTracestate {
+		sc.TraceState = strings.TrimSpace(tracestate)
+	}
+	return sc, true
+}
+
+// Inject writes the trace context of ctx into h for an outgoing request.
+// Without one, h is left alone.
+func Inject(ctx context.Context, h headers.Headers) {
+	sc, ok := spanContext(ctx)
+	if !ok {
+		return
+	}
+	h.Set("traceparent", sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Set("tracestate", sc.TraceState)
+	} else {
+		h.Delete("tracestate")
+	}
+}
@@ -0,0 +1,156 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/felixsolom/http-from-tcp/internal/headers"
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// recorder keeps exported spans in memory.
+type recorder struct {
+	spans []*Span
+}
+
+func (r *recorder) Export(span *Span) error {
+	r.spans = append(r.spans, span)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	// Test: A valid version 00 header
+	sc, err := ParseTraceparent(parent)
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, parent, sc.Traceparent())
+
+	// Test: Future versions may carry more fields
+	_, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	assert.NoError(t, err)
+
+	// Test: Malformed or invalid headers are rejected
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7-01",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01x",
+	} {
+		_, err := ParseTraceparent(bad)
+		assert.ErrorIs(t, err, ErrBadTraceparent, bad)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	h := headers.Headers{"traceparent": parent, "tracestate": "congo=t61rcWkgMzE"}
+	sc, ok := Extract(h)
+	require.True(t, ok)
+
+	tracer := NewTracer(nil)
+	ctx, span := tracer.Start(ContextWithRemote(context.Background(), sc), "work", KindInternal)
+	out := headers.NewHeaders()
+	Inject(ctx, out)
+
+	// Test: Children keep the trace and tracestate but get a new span ID
+	got, ok := Extract(out)
+	require.True(t, ok)
+	assert.Equal(t, sc.TraceID, got.TraceID)
+	assert.Equal(t, span.SpanContext().SpanID, got.SpanID)
+	assert.NotEqual(t, sc.SpanID, got.SpanID)
+	assert.Equal(t, "congo=t61rcWkgMzE", got.TraceState)
+
+	// Test: Without a trace context nothing is injected
+	out = headers.NewHeaders()
+	Inject(context.Background(), out)
+	assert.Empty(t, out)
+
+	// Test: A bad traceparent is ignored
+	_, ok = Extract(headers.Headers{"traceparent": "nope"})
+	assert.False(t, ok)
+}
+
+func TestMiddleware(t *testing.T) {
+	exported := &recorder{}
+	var inner *Span
+	handler := Middleware(NewTracer(exported))(func(w *response.Writer, req *request.Request) {
+		inner = SpanFromContext(req.Context())
+		response.WriteError(w, response.InternalServerError, "")
+	})
+
+	req := servertest.NewRequest("GET", "/things?page=2", nil)
+	req.Headers.Set("traceparent", parent)
+	_, err := servertest.Record(handler, req)
+	require.NoError(t, err)
+
+	// Test: The server span continues the remote trace and is exported
+	require.Len(t, exported.spans, 1)
+	span := exported.spans[0]
+	assert.Same(t, inner, span)
+	assert.Equal(t, "GET /things", span.Name)
+	assert.Equal(t, KindServer, span.Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent.String())
+	assert.Equal(t, "500", span.Attributes["http.response.status_code"])
+	assert.Equal(t, "status 500", span.Error)
+
+	// Test: Unsampled traces are not exported
+	req = servertest.NewRequest("GET", "/", nil)
+	req.Headers.Set("traceparent", strings.TrimSuffix(parent, "01")+"00")
+	_, err = servertest.Record(handler, req)
+	require.NoError(t, err)
+	assert.Len(t, exported.spans, 1)
+
+	// Test: Without a traceparent a new trace starts
+	_, err = servertest.Record(handler, servertest.NewRequest("GET", "/", nil))
+	require.NoError(t, err)
+	require.Len(t, exported.spans, 2)
+	assert.NotEqual(t, span.TraceID, exported.spans[1].TraceID)
+	assert.False(t, exported.spans[1].Parent.IsValid())
+}
+
+func TestJSONExporter(t *testing.T) {
+	var out bytes.Buffer
+	tracer := NewTracer(NewJSONExporter(&out))
+	ctx, root := tracer.Start(context.Background(), "root", KindServer)
+	_, child := tracer.Start(ctx, "child", KindClient)
+	child.SetAttribute("k", "v")
+	child.End()
+	root.End()
+	root.End()
+
+	// Test: One line per span, with hex IDs
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	var got struct {
+		Name       string            `json:"name"`
+		TraceID    string            `json:"trace_id"`
+		Parent     string            `json:"parent_span_id"`
+		Attributes map[string]string `json:"attributes"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &got))
+	assert.Equal(t, "child", got.Name)
+	assert.Equal(t, root.TraceID.String(), got.TraceID)
+	assert.Equal(t, root.SpanID.String(), got.Parent)
+	assert.Equal(t, "v", got.Attributes["k"])
+
+	// Test: Root spans have no parent at all
+	var rootLine map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &rootLine))
+	assert.Equal(t, "root", rootLine["name"])
+	assert.NotContains(t, rootLine, "parent_span_id")
+}
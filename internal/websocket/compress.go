@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// deflateTail ends every compressed message on the wire, senders strip it
+// and receivers put it back (RFC 7692 section 7.2.1).
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// compress deflates one message. Neither side keeps context between
+// messages, so each starts from a fresh compressor.
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+func decompress(data []byte, maxSize int64) ([]byte, error) {
+	// the tail turns the message into a complete stored block, and the
+	// final empty block after it ends the stream cleanly
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail),
+		bytes.NewReader([]byte{0x01, 0x00, 0x00, 0xff, 0xff})))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, &CloseError{Code: CloseInvalidPayload, Text: "bad deflate data"}
+	}
+	if int64(len(out)) > maxSize {
+		return nil, &CloseError{Code: CloseMessageTooBig, Text: "message too big"}
+	}
+	return out, nil
+}
@@ -0,0 +1,411 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	maskBit = 0x80
+
+	maxControlPayload = 125
+	closeTimeout      = 5 * time.Second
+)
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// CloseError is returned by ReadMessage once the connection is closed.
+// Code is CloseAbnormal when it went away without a close frame.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with %d %s", e.Code, e.Text)
+}
+
+// Conn is a server side WebSocket connection. One goroutine may read while
+// others write.
+type Conn struct {
+	conn           net.Conn
+	br             *bufio.Reader
+	subprotocol    string
+	compress       bool
+	maxMessageSize int64
+	fragmentSize   int
+
+	writeMu   sync.Mutex
+	closeSent bool
+
+	// closeErr is set once reading has stopped for good.
+	closeErr error
+	// closeReply is closed when reading stops, usually because the close
+	// frame answering ours arrived.
+	closeReply chan struct{}
+	closeOnce  sync.Once
+}
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  byte
+	payload []byte
+}
+
+// Subprotocol is the negotiated subprotocol, empty when there is none.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage returns the next complete data message. Pings are answered
+// and pongs dropped along the way. A close frame is echoed and ends reading
+// with a *CloseError.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.closeErr != nil {
+		return 0, nil, c.closeErr
+	}
+	var (
+		msgType    MessageType
+		compressed bool
+		data       []byte
+	)
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch f.opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, true, false, f.payload); err != nil {
+				return 0, nil, c.fail(err)
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.receiveClose(f.payload)
+		case opText, opBinary:
+			if msgType != 0 {
+				return 0, nil, c.fail(&CloseError{Code: CloseProtocolError, Text: "new message inside a fragmented one"})
+			}
+			msgType = MessageType(f.opcode)
+			compressed = f.rsv1
+		case opContinuation:
+			if msgType == 0 {
+				return 0, nil, c.fail(&CloseError{Code: CloseProtocolError, Text: "continuation without a message"})
+			}
+		}
+
+		if int64(len(data)+len(f.payload)) > c.maxMessageSize {
+			return 0, nil, c.fail(&CloseError{Code: CloseMessageTooBig, Text: "message too big"})
+		}
+		data = append(data, f.payload...)
+		if !f.fin {
+			continue
+		}
+
+		if compressed {
+			if data, err = decompress(data, c.maxMessageSize); err != nil {
+				return 0, nil, c.fail(err)
+			}
+		}
+		if msgType == TextMessage && !utf8.Valid(data) {
+			return 0, nil, c.fail(&CloseError{Code: CloseInvalidPayload, Text: "text message is not UTF-8"})
+		}
+		return msgType, data, nil
+	}
+}
+
+// WriteMessage sends data as one message, fragmented by the configured
+// fragment size and compressed when that was negotiated.
+func (c *Conn) WriteMessage(msgType MessageType, data []byte) error {
+	if msgType != TextMessage && msgType != BinaryMessage {
+		return fmt.Errorf("websocket: unknown message type %d", msgType)
+	}
+	compressed := false
+	if c.compress {
+		var err error
+		if data, err = compress(data); err != nil {
+			return err
+		}
+		compressed = true
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	opcode := byte(msgType)
+	for {
+		n := len(data)
+		if c.fragmentSize > 0 {
+			n = min(n, c.fragmentSize)
+		}
+		fin := n == len(data)
+		if err := c.writeFrameLocked(opcode, fin, compressed, data[:n]); err != nil {
+			return err
+		}
+		if fin {
+			return nil
+		}
+		data = data[n:]
+		// only the first frame of a message carries the opcode and RSV1
+		opcode, compressed = opContinuation, false
+	}
+}
+
+// Ping sends a ping, the answering pong is consumed by ReadMessage.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: ping payload too long")
+	}
+	return c.writeFrame(opPing, true, false, data)
+}
+
+// Close starts the closing handshake and closes the connection once the
+// client answered it, or after a timeout. ReadMessage must be running in
+// another goroutine for the answer to be seen; without one Close only
+// waits out the timeout.
+func (c *Conn) Close(code int, text string) error {
+	c.writeMu.Lock()
+	if c.closeSent {
+		c.writeMu.Unlock()
+		return nil
+	}
+	err := c.writeFrameLocked(opClose, true, false, closePayload(code, text))
+	c.closeSent = true
+	c.writeMu.Unlock()
+	if err == nil {
+		select {
+		case <-c.closeReply:
+		case <-time.After(closeTimeout):
+		}
+	}
+	c.conn.Close()
+	return err
+}
+
+// receiveClose handles a close frame from the client, which is either the
+// answer to our own or starts the closing handshake.
+func (c *Conn) receiveClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(&CloseError{Code: CloseProtocolError, Text: "close payload of 1 byte"})
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(&CloseError{Code: CloseProtocolError, Text: "invalid close code"})
+		}
+		if !utf8.ValidString(closeErr.Text) {
+			return c.fail(&CloseError{Code: CloseInvalidPayload, Text: "close reason is not UTF-8"})
+		}
+	}
+	c.closeErr = closeErr
+
+	c.writeMu.Lock()
+	if c.closeSent {
+		c.writeMu.Unlock()
+		c.closeOnce.Do(func() { close(c.closeReply) })
+		return closeErr
+	}
+	c.writeFrameLocked(opClose, true, false, closePayload(closeErr.Code, ""))
+	c.closeSent = true
+	c.writeMu.Unlock()
+	c.conn.Close()
+	return closeErr
+}
+
+// fail ends the connection because of err. Protocol violations are told to
+// the client with a close frame first.
+func (c *Conn) fail(err error) error {
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		c.writeMu.Lock()
+		if !c.closeSent {
+			c.writeFrameLocked(opClose, true, false, closePayload(ce.Code, ce.Text))
+			c.closeSent = true
+		}
+		c.writeMu.Unlock()
+	} else {
+		ce = &CloseError{Code: CloseAbnormal, Text: err.Error()}
+	}
+	c.closeErr = ce
+	c.closeOnce.Do(func() { close(c.closeReply) })
+	c.conn.Close()
+	return ce
+}
+
+func (c *Conn) readFrame() (*frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return nil, err
+	}
+	f := &frame{
+		fin:    head[0]&finBit != 0,
+		rsv1:   head[0]&rsv1Bit != 0,
+		opcode: head[0] & 0x0f,
+	}
+	if head[0]&0x30 != 0 {
+		return nil, &CloseError{Code: CloseProtocolError, Text: "reserved bits set"}
+	}
+	if f.rsv1 && (!c.compress || (f.opcode != opText && f.opcode != opBinary)) {
+		return nil, &CloseError{Code: CloseProtocolError, Text: "unexpected RSV1"}
+	}
+	switch f.opcode {
+	case opContinuation, opText, opBinary:
+	case opClose, opPing, opPong:
+		if !f.fin {
+			return nil, &CloseError{Code: CloseProtocolError, Text: "fragmented control frame"}
+		}
+	default:
+		return nil, &CloseError{Code: CloseProtocolError, Text: fmt.Sprintf("unknown opcode %d", f.opcode)}
+	}
+	if head[1]&maskBit == 0 {
+		return nil, &CloseError{Code: CloseProtocolError, Text: "client frames must be masked"}
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if f.opcode >= opClose && length > maxControlPayload {
+		return nil, &CloseError{Code: CloseProtocolError, Text: "control frame too long"}
+	}
+	if length > uint64(c.maxMessageSize) {
+		return nil, &CloseError{Code: CloseMessageTooBig, Text: "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return nil, err
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return nil, err
+	}
+	maskBytes(mask, f.payload)
+	return f, nil
+}
+
+func (c *Conn) writeFrame(opcode byte, fin, rsv1 bool, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return c.writeFrameLocked(opcode, fin, rsv1, payload)
+}
+
+// writeFrameLocked writes an unmasked frame, servers never mask.
+func (c *Conn) writeFrameLocked(opcode byte, fin, rsv1 bool, payload []byte) error {
+	buf := make([]byte, 0, 10+len(payload))
+	b0 := opcode
+	if fin {
+		b0 |= finBit
+	}
+	if rsv1 {
+		b0 |= rsv1Bit
+	}
+	buf = append(buf, b0)
+	switch {
+	case len(payload) < 126:
+		buf = append(buf, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		buf = append(buf, 126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	default:
+		buf = append(buf, 127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(payload)))
+	}
+	buf = append(buf, payload...)
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func maskBytes(mask [4]byte, p []byte) {
+	for i := range p {
+		p[i] ^= mask[i%4]
+	}
+}
+
+func closePayload(code int, text string) []byte {
+	if code == CloseNoStatus {
+		return nil
+	}
+	p := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(text) > maxControlPayload-2 {
+		text = text[:maxControlPayload-2]
+	}
+	return append(p, text...)
+}
+
+// validCloseCode tells whether code may appear in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1014:
+		return false
+	}
+	switch code {
+	case 1004, CloseNoStatus, CloseAbnormal:
+		return false
+	}
+	return true
+}
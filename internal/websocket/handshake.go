@@ -0,0 +1,197 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+)
+
+// acceptGUID is appended to the client's key to prove the server speaks
+// WebSocket, RFC 6455 section 1.3.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	defaultMaxMessageSize = 1 << 20
+	defaultFragmentSize   = 64 << 10
+)
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+type Option func(*config)
+
+type config struct {
+	subprotocols   []string
+	maxMessageSize int64
+	fragmentSize   int
+	compression    bool
+	checkOrigin    func(req *request.Request) bool
+}
+
+// WithSubprotocols lists the subprotocols the server speaks, most preferred
+// first. The first one the client offered too is selected.
+func WithSubprotocols(protocols ...string) Option {
+	return func(c *config) { c.subprotocols = protocols }
+}
+
+// WithMaxMessageSize limits incoming messages, after decompression. Larger
+// ones close the connection with CloseMessageTooBig. The default is 1 MiB.
+func WithMaxMessageSize(n int64) Option {
+	return func(c *config) { c.maxMessageSize = n }
+}
+
+// WithFragmentSize splits outgoing messages into frames of at most n bytes.
+// The default is 64 KiB.
+func WithFragmentSize(n int) Option {
+	return func(c *config) { c.fragmentSize = n }
+}
+
+// WithCompression accepts the permessage-deflate extension when the client
+// offers it.
+func WithCompression() Option {
+	return func(c *config) { c.compression = true }
+}
+
+// WithOriginCheck refuses the handshake with a 403 when check returns false.
+// Without it every origin is allowed.
+func WithOriginCheck(check func(req *request.Request) bool) Option {
+	return func(c *config) { c.checkOrigin = check }
+}
+
+// Upgrade completes the opening handshake for req and takes over the
+// connection. When the request isn't a valid handshake an error response
+// is written and ErrBadHandshake returned.
+func Upgrade(w *response.Writer, req *request.Request, opts ...Option) (*Conn, error) {
+	cfg := config{maxMessageSize: defaultMaxMessageSize, fragmentSize: defaultFragmentSize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if req.RequestLine.Method != "GET" {
+		response.WriteError(w, response.MethodNotAllowed, "")
+		return nil, fmt.Errorf("%w: method %s", ErrBadHandshake, req.RequestLine.Method)
+	}
+	upgrade, _ := req.Headers.Get("Upgrade")
+	connection, _ := req.Headers.Get("Connection")
+	if !hasToken(upgrade, "websocket") || !hasToken(connection, "upgrade") {
+		response.WriteError(w, response.BadRequest, "not a websocket handshake")
+		return nil, fmt.Errorf("%w: missing upgrade headers", ErrBadHandshake)
+	}
+	if version, _ := req.Headers.Get("Sec-WebSocket-Version"); version != "13" {
+		body := []byte("unsupported websocket version, 13 is spoken here\n")
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("Sec-WebSocket-Version", "13")
+		w.WriteStatusLine(response.UpgradeRequired)
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+		return nil, fmt.Errorf("%w: version %q", ErrBadHandshake, version)
+	}
+	key, _ := req.Headers.Lookup("Sec-WebSocket-Key")
+	key = strings.TrimSpace(key)
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		response.WriteError(w, response.BadRequest, "bad Sec-WebSocket-Key")
+		return nil, fmt.Errorf("%w: key %q", ErrBadHandshake, key)
+	}
+	if cfg.checkOrigin != nil && !cfg.checkOrigin(req) {
+		response.WriteError(w, response.Forbidden, "")
+		return nil, fmt.Errorf("%w: origin not allowed", ErrBadHandshake)
+	}
+
+	offered, _ := req.Headers.Lookup("Sec-WebSocket-Protocol")
+	subprotocol := selectSubprotocol(offered, cfg.subprotocols)
+	extensions, _ := req.Headers.Lookup("Sec-WebSocket-Extensions")
+	compress := cfg.compression && acceptDeflate(extensions)
+
+	netConn, buffered, err := w.Hijack()
+	if err != nil {
+		response.WriteError(w, response.InternalServerError, "")
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	fmt.Fprintf(&b, "Sec-WebSocket-Accept: %s\r\n", AcceptKey(key))
+	if subprotocol != "" {
+		fmt.Fprintf(&b, "Sec-WebSocket-Protocol: %s\r\n", subprotocol)
+	}
+	if compress {
+		b.WriteString("Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+	}
+	b.WriteString("\r\n")
+	if _, err := netConn.Write([]byte(b.String())); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:           netConn,
+		br:             bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), netConn)),
+		subprotocol:    subprotocol,
+		compress:       compress,
+		maxMessageSize: cfg.maxMessageSize,
+		fragmentSize:   cfg.fragmentSize,
+		closeReply:     make(chan struct{}),
+	}, nil
+}
+
+// AcceptKey computes Sec-WebSocket-Accept for a client's Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func selectSubprotocol(offered string, supported []string) string {
+	for _, s := range supported {
+		for _, o := range strings.Split(offered, ",") {
+			if strings.TrimSpace(o) == s {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// acceptDeflate looks for a permessage-deflate offer we can take. Window
+// sizes below the 32 KiB compress/flate always uses can't be honoured, so
+// offers asking for them are skipped.
+func acceptDeflate(extensions string) bool {
+	for _, offer := range strings.Split(extensions, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		ok := true
+		for _, p := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+			switch strings.TrimSpace(name) {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				if strings.Trim(strings.TrimSpace(value), `"`) != "15" {
+					ok = false
+				}
+			default:
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,277 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felixsolom/http-from-tcp/internal/request"
+	"github.com/felixsolom/http-from-tcp/internal/response"
+	"github.com/felixsolom/http-from-tcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	head string
+}
+
+// echoServer upgrades every request and echoes messages back until the
+// client closes.
+func echoServer(t *testing.T, opts ...Option) *servertest.Server {
+	s, err := servertest.NewServer(func(w *response.Writer, req *request.Request) {
+		c, err := Upgrade(w, req, opts...)
+		if err != nil {
+			return
+		}
+		for {
+			msgType, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(data) == "close please" {
+				go c.Close(CloseNormal, "bye")
+				continue
+			}
+			c.WriteMessage(msgType, data)
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func dial(t *testing.T, s *servertest.Server, extraHeaders ...string) *testClient {
+	conn, err := net.Dial("tcp", s.Addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	req := "GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 13\r\n"
+	for _, h := range extraHeaders {
+		req += h + "\r\n"
+	}
+	_, err = io.WriteString(conn, req+"\r\n")
+	require.NoError(t, err)
+
+	tc := &testClient{t: t, conn: conn, br: bufio.NewReader(conn)}
+	for {
+		line, err := tc.br.ReadString('\n')
+		require.NoError(t, err)
+		tc.head += line
+		if line == "\r\n" {
+			return tc
+		}
+	}
+}
+
+func (tc *testClient) writeFrame(b0 byte, payload []byte) {
+	buf := []byte{b0}
+	switch {
+	case len(payload) < 126:
+		buf = append(buf, maskBit|byte(len(payload)))
+	default:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	buf = append(buf, mask[:]...)
+	masked := append([]byte{}, payload...)
+	maskBytes(mask, masked)
+	_, err := tc.conn.Write(append(buf, masked...))
+	require.NoError(tc.t, err)
+}
+
+func (tc *testClient) readFrame() (byte, []byte) {
+	var head [2]byte
+	_, err := io.ReadFull(tc.br, head[:])
+	require.NoError(tc.t, err)
+	require.Zero(tc.t, head[1]&maskBit, "server frames are never masked")
+	length := int(head[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(tc.br, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(tc.br, payload)
+	require.NoError(tc.t, err)
+	return head[0], payload
+}
+
+func TestAcceptKey(t *testing.T) {
+	// Test: Example from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey(testKey))
+}
+
+func TestHandshake(t *testing.T) {
+	s := echoServer(t, WithSubprotocols("chat.v2", "chat.v1"))
+
+	// Test: Accept key and subprotocol
+	tc := dial(t, s, "Sec-WebSocket-Protocol: chat.v1, chat.v2")
+	assert.True(t, strings.HasPrefix(tc.head, "HTTP/1.1 101 Switching Protocols\r\n"))
+	assert.Contains(t, tc.head, "Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.Contains(t, tc.head, "Sec-WebSocket-Protocol: chat.v2\r\n")
+	assert.NotContains(t, tc.head, "Sec-WebSocket-Extensions")
+
+	// Test: Wrong version
+	rec := servertest.NewRecorder()
+	req := servertest.NewRequest("GET", "/ws", nil)
+	req.Headers["upgrade"] = "websocket"
+	req.Headers["connection"] = "Upgrade"
+	req.Headers["sec-websocket-key"] = testKey
+	req.Headers["sec-websocket-version"] = "8"
+	_, err := Upgrade(rec.Writer, req)
+	assert.ErrorIs(t, err, ErrBadHandshake)
+	res, err := rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.UpgradeRequired, res.StatusLine.StatusCode)
+	assert.Equal(t, "13", res.Headers["sec-websocket-version"])
+
+	// Test: Not an upgrade at all
+	rec = servertest.NewRecorder()
+	_, err = Upgrade(rec.Writer, servertest.NewRequest("GET", "/ws", nil))
+	assert.ErrorIs(t, err, ErrBadHandshake)
+	res, err = rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.BadRequest, res.StatusLine.StatusCode)
+
+	// Test: Recorders can't be hijacked
+	rec = servertest.NewRecorder()
+	req.Headers["sec-websocket-version"] = "13"
+	_, err = Upgrade(rec.Writer, req)
+	assert.ErrorIs(t, err, response.ErrNotHijackable)
+}
+
+func TestMessages(t *testing.T) {
+	tc := dial(t, echoServer(t, WithFragmentSize(4)))
+
+	// Test: Masked text message comes back fragmented
+	tc.writeFrame(finBit|opText, []byte("hello world"))
+	var got []byte
+	b0, p := tc.readFrame()
+	assert.Equal(t, byte(opText), b0)
+	got = append(got, p...)
+	for b0&finBit == 0 {
+		b0, p = tc.readFrame()
+		assert.Equal(t, byte(opContinuation), b0&0x0f)
+		got = append(got, p...)
+	}
+	assert.Equal(t, "hello world", string(got))
+
+	// Test: Fragmented input with a ping in between
+	tc.writeFrame(opBinary, []byte("ab"))
+	tc.writeFrame(finBit|opPing, []byte("are you there"))
+	b0, p = tc.readFrame()
+	assert.Equal(t, byte(finBit|opPong), b0)
+	assert.Equal(t, "are you there", string(p))
+	tc.writeFrame(finBit|opContinuation, []byte("cd"))
+	b0, p = tc.readFrame()
+	assert.Equal(t, byte(finBit|opBinary), b0)
+	assert.Equal(t, "abcd", string(p))
+
+	// Test: Client initiated close is echoed
+	tc.writeFrame(finBit|opClose, closePayload(CloseGoingAway, "leaving"))
+	b0, p = tc.readFrame()
+	assert.Equal(t, byte(finBit|opClose), b0)
+	assert.Equal(t, closePayload(CloseGoingAway, ""), p)
+}
+
+func TestEarlyFrame(t *testing.T) {
+	// Test: Frame sent along with the handshake isn't lost
+	s := echoServer(t)
+	conn, err := net.Dial("tcp", s.Addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	tc := &testClient{t: t, conn: conn, br: bufio.NewReader(conn)}
+	var frame bytes.Buffer
+	mask := [4]byte{1, 2, 3, 4}
+	payload := []byte("early")
+	maskBytes(mask, payload)
+	frame.Write([]byte{finBit | opText, maskBit | 5})
+	frame.Write(mask[:])
+	frame.Write(payload)
+	_, err = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+testKey+"\r\nSec-WebSocket-Version: 13\r\n\r\n"+frame.String())
+	require.NoError(t, err)
+	for {
+		line, err := tc.br.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+	b0, p := tc.readFrame()
+	assert.Equal(t, byte(finBit|opText), b0)
+	assert.Equal(t, "early", string(p))
+}
+
+func TestServerClose(t *testing.T) {
+	tc := dial(t, echoServer(t))
+	tc.writeFrame(finBit|opText, []byte("close please"))
+	b0, p := tc.readFrame()
+	assert.Equal(t, byte(finBit|opClose), b0)
+	assert.Equal(t, closePayload(CloseNormal, "bye"), p)
+	tc.writeFrame(finBit|opClose, closePayload(CloseNormal, ""))
+	_, err := tc.br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestProtocolViolations(t *testing.T) {
+	s := echoServer(t, WithMaxMessageSize(8))
+	cases := []struct {
+		name string
+		send func(tc *testClient)
+		code int
+	}{
+		{"unmasked", func(tc *testClient) { tc.conn.Write([]byte{finBit | opText, 2, 'h', 'i'}) }, CloseProtocolError},
+		{"too big", func(tc *testClient) { tc.writeFrame(finBit|opText, []byte("way more than eight bytes")) }, CloseMessageTooBig},
+		{"bad utf8", func(tc *testClient) { tc.writeFrame(finBit|opText, []byte{0xff, 0xfe}) }, CloseInvalidPayload},
+		{"stray continuation", func(tc *testClient) { tc.writeFrame(finBit|opContinuation, []byte("x")) }, CloseProtocolError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tc := dial(t, s)
+			c.send(tc)
+			b0, p := tc.readFrame()
+			assert.Equal(t, byte(finBit|opClose), b0)
+			require.GreaterOrEqual(t, len(p), 2)
+			assert.Equal(t, c.code, int(binary.BigEndian.Uint16(p)))
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	tc := dial(t, echoServer(t, WithCompression()), "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits")
+	assert.Contains(t, tc.head, "Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+
+	msg := bytes.Repeat([]byte("compress me "), 20)
+	compressed, err := compress(msg)
+	require.NoError(t, err)
+	assert.Less(t, len(compressed), len(msg))
+	tc.writeFrame(finBit|rsv1Bit|opText, compressed)
+
+	b0, p := tc.readFrame()
+	assert.Equal(t, byte(finBit|rsv1Bit|opText), b0)
+	out, err := decompress(p, 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, msg, out)
+}
+
+func TestAcceptDeflate(t *testing.T) {
+	assert.True(t, acceptDeflate("permessage-deflate"))
+	assert.True(t, acceptDeflate("x-webkit-deflate-frame, permessage-deflate; server_max_window_bits=15"))
+	assert.False(t, acceptDeflate("permessage-deflate; server_max_window_bits=10"))
+	assert.False(t, acceptDeflate(""))
+}